@@ -0,0 +1,119 @@
+package jukybox
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type AlarmRule struct {
+	days   [7]bool
+	hour   int
+	minute int
+	album  string
+	ramp   time.Duration
+}
+
+var alarmDayNames = map[string][]time.Weekday{
+	"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+}
+
+func parseAlarmRule(config AlarmConfig) (AlarmRule, error) {
+	rule := AlarmRule{
+		album: config.Album,
+	}
+
+	days := config.Days
+	if len(days) == 0 {
+		days = []string{"daily"}
+	}
+	for _, day := range days {
+		weekdays, ok := alarmDayNames[strings.ToLower(day)]
+		if !ok {
+			return rule, fmt.Errorf("unknown day: %s", day)
+		}
+		for _, weekday := range weekdays {
+			rule.days[weekday] = true
+		}
+	}
+
+	t, err := time.Parse("15:04", config.Time)
+	if err != nil {
+		return rule, fmt.Errorf("invalid time %q: %v", config.Time, err)
+	}
+	rule.hour = t.Hour()
+	rule.minute = t.Minute()
+
+	if len(config.Ramp) > 0 {
+		ramp, err := time.ParseDuration(config.Ramp)
+		if err != nil {
+			return rule, fmt.Errorf("invalid ramp %q: %v", config.Ramp, err)
+		}
+		rule.ramp = ramp
+	}
+	return rule, nil
+}
+
+// Returns the first time strictly after `after` at which the rule fires.
+func (r *AlarmRule) next(after time.Time) (time.Time, bool) {
+	for i := 0; i <= 7; i++ {
+		day := after.AddDate(0, 0, i)
+		t := time.Date(day.Year(), day.Month(), day.Day(), r.hour, r.minute, 0, 0, after.Location())
+		if t.After(after) && r.days[t.Weekday()] {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type Scheduler struct {
+	rules []AlarmRule
+	clock Clock
+}
+
+func CreateScheduler(configs []AlarmConfig, clock Clock) *Scheduler {
+	scheduler := Scheduler{clock: clock}
+	for _, config := range configs {
+		rule, err := parseAlarmRule(config)
+		if err != nil {
+			log.Printf("Ignoring alarm: %v", err)
+			continue
+		}
+		scheduler.rules = append(scheduler.rules, rule)
+	}
+	return &scheduler
+}
+
+// Next returns the first alarm after the current time of the scheduler's clock.
+func (s *Scheduler) Next() (time.Time, *AlarmRule) {
+	now := s.clock.Now()
+	var next time.Time
+	var nextRule *AlarmRule
+	for i := range s.rules {
+		if t, ok := s.rules[i].next(now); ok && (nextRule == nil || t.Before(next)) {
+			next = t
+			nextRule = &s.rules[i]
+		}
+	}
+	return next, nextRule
+}
+
+// Wait returns a channel that fires when the next alarm goes off, or nil if
+// there are no alarms.
+func (s *Scheduler) Wait() (<-chan time.Time, time.Time, *AlarmRule) {
+	next, rule := s.Next()
+	if rule == nil {
+		return nil, next, nil
+	}
+	return s.clock.After(next.Sub(s.clock.Now())), next, rule
+}
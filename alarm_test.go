@@ -0,0 +1,217 @@
+package jukybox
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func mustParseAlarmRule(t *testing.T, config AlarmConfig) AlarmRule {
+	t.Helper()
+	rule, err := parseAlarmRule(config)
+	if err != nil {
+		t.Fatalf("parseAlarmRule(%+v): %v", config, err)
+	}
+	return rule
+}
+
+func TestAlarmRuleNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		config AlarmConfig
+		after  time.Time
+		want   time.Time
+	}{
+		{
+			"weekday later today",
+			AlarmConfig{Days: []string{"weekdays"}, Time: "07:00"},
+			time.Date(2024, 3, 4, 6, 0, 0, 0, time.UTC), // Monday
+			time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			// Strictly after, so an alarm doesn't fire twice
+			"weekday at the alarm time",
+			AlarmConfig{Days: []string{"weekdays"}, Time: "07:00"},
+			time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 5, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			"weekdays skip the weekend",
+			AlarmConfig{Days: []string{"weekdays"}, Time: "07:00"},
+			time.Date(2024, 3, 8, 8, 0, 0, 0, time.UTC), // Friday
+			time.Date(2024, 3, 11, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			"weekends",
+			AlarmConfig{Days: []string{"weekends"}, Time: "09:30"},
+			time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC), // Monday
+			time.Date(2024, 3, 9, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			"weekends from saturday to sunday",
+			AlarmConfig{Days: []string{"weekends"}, Time: "09:30"},
+			time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 10, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			"single day a week later",
+			AlarmConfig{Days: []string{"Wed"}, Time: "18:00"},
+			time.Date(2024, 3, 6, 18, 0, 0, 0, time.UTC), // Wednesday
+			time.Date(2024, 3, 13, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			"daily by default, across midnight",
+			AlarmConfig{Time: "00:05"},
+			time.Date(2024, 3, 4, 23, 59, 0, 0, time.UTC),
+			time.Date(2024, 3, 5, 0, 5, 0, 0, time.UTC),
+		},
+		{
+			"across the end of the year",
+			AlarmConfig{Days: []string{"weekdays"}, Time: "07:00"},
+			time.Date(2021, 12, 31, 8, 0, 0, 0, time.UTC), // Friday
+			time.Date(2022, 1, 3, 7, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := mustParseAlarmRule(t, test.config)
+			got, ok := rule.next(test.after)
+			if !ok || !got.Equal(test.want) {
+				t.Errorf("next(%v) = %v, %v, expected %v", test.after, got, ok, test.want)
+			}
+		})
+	}
+}
+
+// The alarm goes off at the same wall clock time on the day the clocks change
+func TestAlarmRuleNextAcrossDST(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	rule := mustParseAlarmRule(t, AlarmConfig{Time: "07:00"})
+
+	// Clocks go forward on 31 March 2024, so the night is an hour shorter
+	after := time.Date(2024, 3, 30, 7, 0, 0, 0, location)
+	got, _ := rule.next(after)
+	if want := time.Date(2024, 3, 31, 7, 0, 0, 0, location); !got.Equal(want) || got.Sub(after) != 23*time.Hour {
+		t.Errorf("next(%v) = %v, expected %v, 23h later", after, got, want)
+	}
+
+	// Clocks go back on 27 October 2024
+	after = time.Date(2024, 10, 26, 7, 0, 0, 0, location)
+	got, _ = rule.next(after)
+	if want := time.Date(2024, 10, 27, 7, 0, 0, 0, location); !got.Equal(want) || got.Sub(after) != 25*time.Hour {
+		t.Errorf("next(%v) = %v, expected %v, 25h later", after, got, want)
+	}
+}
+
+func TestParseAlarmRuleErrors(t *testing.T) {
+	for _, config := range []AlarmConfig{
+		{Time: "7am"},
+		{Days: []string{"someday"}, Time: "07:00"},
+		{Time: "07:00", Ramp: "slowly"},
+	} {
+		if _, err := parseAlarmRule(config); err == nil {
+			t.Errorf("parseAlarmRule(%+v) succeeded", config)
+		}
+	}
+}
+
+func TestSchedulerFiresWhenClockPassesAlarm(t *testing.T) {
+	start := time.Date(2024, 3, 8, 6, 0, 0, 0, time.UTC) // Friday
+	clock := NewManualClock(start)
+	scheduler := CreateScheduler([]AlarmConfig{
+		{Days: []string{"weekdays"}, Time: "07:00", Album: "Morning"},
+		{Days: []string{"weekends"}, Time: "09:00"},
+		// Invalid rules are ignored
+		{Time: "never"},
+	}, clock)
+
+	events, next, rule := scheduler.Wait()
+	if rule == nil || rule.album != "Morning" || !next.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Wait() = %v, %+v, expected the Morning alarm at 07:00", next, rule)
+	}
+	clock.Advance(59 * time.Minute)
+	select {
+	case <-events:
+		t.Fatalf("alarm fired at %v", clock.Now())
+	default:
+	}
+	clock.Advance(time.Minute)
+	select {
+	case fired := <-events:
+		if !fired.Equal(next) {
+			t.Errorf("alarm fired at %v, expected %v", fired, next)
+		}
+	default:
+		t.Fatalf("alarm didn't fire at %v", clock.Now())
+	}
+
+	// The weekend alarm is next
+	_, next, rule = scheduler.Wait()
+	if want := time.Date(2024, 3, 9, 9, 0, 0, 0, time.UTC); rule == nil || rule.album != "" || !next.Equal(want) {
+		t.Errorf("Wait() = %v, %+v, expected the weekend alarm at %v", next, rule, want)
+	}
+}
+
+// Polls the state of the app until done returns true
+func waitForState(t *testing.T, app *App, what string, done func(status PlayerStatus) bool) PlayerStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := app.State()
+		if err != nil {
+			t.Fatalf("State: %v", err)
+		}
+		if done(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s: %+v", what, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAlarmStartsPlayback(t *testing.T) {
+	start := time.Date(2024, 3, 8, 6, 59, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	player := &fakeAudioPlayer{}
+	app := newTestApp(t, Options{
+		Config: Config{
+			MediaDirs: []string{createLibrary(t)},
+			Alarms:    []AlarmConfig{{Time: "07:00", Album: "Two", Ramp: "1m"}},
+		},
+		Clock:       clock,
+		AudioPlayer: player,
+	})
+	two := findTestAlbum(t, app, "Two")
+
+	clock.Advance(59 * time.Second)
+	if status, _ := app.State(); status.State != Stopped {
+		t.Fatalf("playing before the alarm: %+v", status)
+	}
+	clock.Advance(time.Second)
+	status := waitForState(t, app, "the alarm", func(status PlayerStatus) bool { return status.State == Playing })
+	if status.Album != two.ID {
+		t.Errorf("alarm played %q, expected %q", status.Album, two.ID)
+	}
+
+	// Halfway through the ramp, at the default volume of 0.8
+	clock.Advance(30 * time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for math.Abs(player.Volume()-0.4) > 0.001 {
+		if time.Now().After(deadline) {
+			t.Fatalf("volume is %v halfway through the ramp, expected 0.4", player.Volume())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerWithoutAlarms(t *testing.T) {
+	scheduler := CreateScheduler(nil, NewManualClock(time.Now()))
+	if events, _, rule := scheduler.Wait(); events != nil || rule != nil {
+		t.Errorf("Wait() = %v, %v, expected no alarm", events, rule)
+	}
+}
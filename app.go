@@ -1,12 +1,17 @@
 package jukybox
 
 import (
+	"errors"
+	"fmt"
 	"github.com/remko/jukybox/audioplayer"
 	"github.com/remko/jukybox/ffmpeg"
+	"github.com/remko/jukybox/history"
 	"log"
+	"math"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -28,113 +33,526 @@ func findChapter(file *MediaFile, position time.Duration) (Chapter, int, bool) {
 	return Chapter{}, -1, false
 }
 
+var ErrStopped = errors.New("jukybox: stopped")
+
+type appRequest struct {
+	f      func() error
+	result chan error
+}
+
 type App struct {
 	buttonEvents chan Button
-	done         chan bool
-	display      Display
+	requests     chan appRequest
+	// Closed by Close
+	closing   chan struct{}
+	closeOnce sync.Once
+	// Closed when the control loop stops taking requests, and when it has
+	// released everything
+	stopped  chan struct{}
+	finished chan struct{}
+	// Nil when running without a display
+	display       Display
+	frontends     []Frontend
+	handleSignals bool
+	config        Config
+	clock         Clock
+	savedState    *savedState
 
 	mediaFiles       []*MediaFile
 	mediaFilesByFile map[string]mediaFileAndIndex
 
 	audioPlayer audioplayer.AudioPlayer
-	decoder     *ffmpeg.FFmpeg
-	passthrough bool
+	pipeline    *pipeline
+	// Generation of the frames in the pipeline coming from the current file
+	// and position
+	generation int
 
 	playerState      PlayerState
 	currentFileIndex int
-	currentPosition  time.Duration
+	// Position of the audio currently coming out of the speakers
+	currentPosition time.Duration
+	// Whether the position is still moving towards a new seek position
+	seeking bool
+	// Part of the current file that is repeated, or -1 if not set
+	loopStart time.Duration
+	loopEnd   time.Duration
+
+	scheduler     *Scheduler
+	alarmEvents   <-chan time.Time
+	nextAlarm     time.Time
+	nextAlarmRule *AlarmRule
+	rampStart     time.Time
+	rampDuration  time.Duration
+
+	overlay        *Overlay
+	overlayTimeout <-chan time.Time
+
+	muted         bool
+	appliedVolume float64
+	// Fires when the volume keys were released, to save the volume once
+	volumeSaveTimeout <-chan time.Time
+
+	queue playQueue
+	// Queue item that is currently playing, if any
+	queueItem *QueueItem
+	showQueue bool
+
+	// Direction of the last album change, to skip unplayable albums
+	direction int
+	// Fires when the media dirs should be scanned again, while there's no media
+	rescanEvents <-chan time.Time
+
+	// Set while the audio device is failing
+	deviceErr error
+
+	// Audio streams of the current file, and the index of the one playing
+	audioStreams []ffmpeg.StreamInfo
+	audioStream  int
+	// How the audio stream is converted for the audio player
+	conversion ffmpeg.Conversion
+
+	// History entry for what is playing now, if any
+	listening *history.Entry
+
+	numberEntry        *numberEntry
+	numberEntryTimeout <-chan time.Time
+
+	kidMode *kidMode
+	// Why kid mode stopped playback
+	kidModeMessage string
+	// Set when some kid mode settings were invalid, and only anytime albums play
+	kidModeConfigErr error
+	fadeStart        time.Time
+	listenTick       time.Time
+	listenSaved      time.Time
+
+	events *EventBus
+	// Last published state, to only publish changes
+	publishedState    PlayerState
+	publishedFile     int
+	publishedChapter  int
+	publishedPosition time.Duration
 }
 
+const mediaRescanInterval = 10 * time.Second
+
 type mediaFileAndIndex struct {
 	index int
 	file  *MediaFile
 }
 
+// Frontend is a way of controlling the app besides the API, such as a remote.
+// It sends the buttons pressed on it to buttonEvents, and can follow the
+// player by subscribing to the app.
+type Frontend interface {
+	Start(app *App, buttonEvents chan<- Button)
+	// Called when the app stops
+	Stop()
+}
+
+// Options for NewApp. Everything that is left out isn't used, except for the
+// clock and the audio player, which default to the system clock and the audio
+// device of the config.
+type Options struct {
+	// Configuration, e.g. as loaded by LoadConfig
+	Config Config
+	// Drives alarms and kid mode, e.g. a ManualClock in tests
+	Clock       Clock
+	AudioPlayer audioplayer.AudioPlayer
+	// Creates the display, which sends the buttons pressed on it to
+	// buttonEvents. Run has to be called to show it.
+	CreateDisplay func(buttonEvents chan<- Button) Display
+	Frontends     []Frontend
+	// Stops the app on SIGINT and SIGTERM
+	HandleSignals bool
+}
+
+// CreateApp creates the app as it runs on the box: with the config file, the
+// display, the console and the remotes.
 func CreateApp() *App {
-	audioPlayer, err := audioplayer.Create()
+	app, err := NewApp(Options{
+		Config:        LoadConfig(),
+		CreateDisplay: func(buttonEvents chan<- Button) Display { return CreateDisplay(buttonEvents) },
+		Frontends:     []Frontend{&consoleFrontend{}, remoteFrontend{}},
+		HandleSignals: true,
+	})
 	if err != nil {
-		log.Printf("ERROR: %v", err)
+		log.Fatalf("ERROR: %v", err)
+	}
+	return app
+}
+
+// NewApp creates an app, and starts loading the media and taking requests.
+// Close stops it again.
+func NewApp(options Options) (*App, error) {
+	config := options.Config
+	clock := options.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	audioPlayer := options.AudioPlayer
+	if audioPlayer == nil {
+		var err error
+		if audioPlayer, err = audioplayer.Create(config.AudioDevice); err != nil {
+			return nil, err
+		}
 	}
 
 	app := App{
 		currentFileIndex: -1,
-		done:             make(chan bool),
+		closing:          make(chan struct{}),
+		stopped:          make(chan struct{}),
+		finished:         make(chan struct{}),
 		buttonEvents:     make(chan Button, 2),
+		requests:         make(chan appRequest),
+		frontends:        options.Frontends,
+		handleSignals:    options.HandleSignals,
+		config:           config,
+		clock:            clock,
+		savedState:       loadSavedState(config.StateDir),
+		appliedVolume:    -1,
+		loopStart:        -1,
+		loopEnd:          -1,
+		direction:        1,
+		events:           NewEventBus(),
+		publishedState:   -1,
+		publishedFile:    -1,
 		audioPlayer:      audioPlayer,
+		scheduler:        CreateScheduler(config.Alarms, clock),
 	}
-	app.display = CreateDisplay(app.buttonEvents)
-	return &app
+	if config.KidMode != nil {
+		mode, err := parseKidMode(*config.KidMode)
+		if err != nil {
+			log.Printf("ERROR: Kid mode: %v", err)
+			app.kidModeConfigErr = err
+		}
+		app.kidMode = mode
+	}
+	app.pipeline = createPipeline(audioPlayer, ffmpeg.Options{
+		Languages:    config.AudioLanguages,
+		ChannelOrder: config.ChannelOrder,
+		Downmix: ffmpeg.Downmix{
+			Mono:          config.Downmix.Mono,
+			CenterLevel:   config.Downmix.CenterLevel,
+			SurroundLevel: config.Downmix.SurroundLevel,
+			LFELevel:      config.Downmix.LFELevel,
+			Matrix:        config.Downmix.Matrix,
+		},
+	})
+	if options.CreateDisplay != nil {
+		app.display = options.CreateDisplay(app.buttonEvents)
+	}
+	go app.run()
+	return &app, nil
 }
 
+// Run shows the display until the app stops: when the power button is
+// pressed, on a signal if Options.HandleSignals is set, or on Close. Some
+// displays have to run on the main goroutine.
 func (app *App) Run() {
-	go app.run()
-	app.display.Run()
+	if app.display != nil {
+		app.display.Run()
+	}
 	log.Printf("Waiting for done signal\n")
-	<-app.done
+	<-app.finished
+}
+
+// Close stops the app, and returns once playback stopped and the audio player
+// and front-ends were let go.
+func (app *App) Close() error {
+	app.closeOnce.Do(func() {
+		close(app.closing)
+	})
+	<-app.finished
+	return nil
+}
+
+// Runs f on the control loop, so that it can safely access the app state.
+func (app *App) call(f func() error) error {
+	request := appRequest{f: f, result: make(chan error, 1)}
+	select {
+	case app.requests <- request:
+	case <-app.stopped:
+		return ErrStopped
+	}
+	select {
+	case err := <-request.result:
+		return err
+	case <-app.stopped:
+		return ErrStopped
+	}
 }
 
 func (app *App) updateDisplay() {
+	if len(app.mediaFiles) == 0 {
+		app.displayMessage("No media")
+		return
+	}
+
 	displayInfo := DisplayInfo{
-		position:        app.currentPosition,
-		duration:        -1,
-		chapterIndex:    1,
-		chapterDuration: -1,
+		Position:        app.currentPosition,
+		Duration:        -1,
+		ChapterIndex:    1,
+		ChapterDuration: -1,
+		LoopStart:       -1,
+		LoopEnd:         -1,
 	}
 
 	switch app.playerState {
 	// case Paused:
 	// 	displayInfo.stateIcon = "\u23F8"
 	case Playing:
-		displayInfo.stateIcon = "\u25B6"
+		displayInfo.StateIcon = "\u25B6"
 	case Stopped:
-		displayInfo.stateIcon = "\u25A0"
+		displayInfo.StateIcon = "\u25A0"
+		if app.nextAlarmRule != nil {
+			displayInfo.NextAlarm = app.nextAlarm.Format("Mon 15:04")
+		}
+	}
+	displayInfo.Favourite = app.isCurrentFavourite()
+
+	displayInfo.Overlay = app.overlay
+	if app.deviceErr != nil && displayInfo.Overlay == nil {
+		displayInfo.Overlay = &Overlay{Icon: "\u26A0", Text: "No audio device", Level: -1}
+	}
+	if app.kidModeConfigErr != nil && displayInfo.Overlay == nil {
+		displayInfo.Overlay = &Overlay{Icon: "\u26A0", Text: "Kid mode config error", Level: -1}
+	}
+	if len(app.kidModeMessage) > 0 && displayInfo.Overlay == nil {
+		displayInfo.Overlay = &Overlay{Icon: "\u23F2", Text: app.kidModeMessage, Level: -1}
+	}
+	if app.numberEntry != nil {
+		displayInfo.Overlay = app.numberEntry.overlay()
 	}
 
 	mediaFile := app.currentFile()
 
 	file := filepath.Base(mediaFile.file)
 	file = file[:len(file)-len(filepath.Ext(file))]
-	displayInfo.title = file
-	displayInfo.chapterPosition = displayInfo.position
+	displayInfo.Title = file
+	displayInfo.ChapterPosition = displayInfo.Position
 
-	displayInfo.duration = mediaFile.duration
-	displayInfo.chapterDuration = mediaFile.duration
+	displayInfo.Duration = mediaFile.duration
+	displayInfo.ChapterDuration = mediaFile.duration
 
 	if len(mediaFile.title) > 0 {
-		displayInfo.title = mediaFile.title
+		displayInfo.Title = mediaFile.title
 	}
 	if len(mediaFile.artist) > 0 {
-		displayInfo.artist = mediaFile.artist
+		displayInfo.Artist = mediaFile.artist
 	}
-	if chapter, chapterIndex, ok := findChapter(mediaFile, displayInfo.position); ok {
-		displayInfo.chapterTitle = chapter.title
-		displayInfo.chapterIndex = chapterIndex + 1
-		displayInfo.chapterPosition = displayInfo.position - chapter.start
-		displayInfo.chapterDuration = chapter.end - chapter.start
+	if chapter, chapterIndex, ok := findChapter(mediaFile, displayInfo.Position); ok {
+		displayInfo.ChapterTitle = chapter.title
+		displayInfo.ChapterIndex = chapterIndex + 1
+		displayInfo.ChapterPosition = displayInfo.Position - chapter.start
+		displayInfo.ChapterDuration = chapter.end - chapter.start
+		if app.loopStart >= 0 {
+			displayInfo.LoopStart = app.loopStart - chapter.start
+		}
+		if app.loopEnd >= 0 {
+			displayInfo.LoopEnd = app.loopEnd - chapter.start
+		}
 	} else {
-		log.Printf("No chapter found %v %v", mediaFile.file, displayInfo.position)
+		log.Printf("No chapter found %v %v", mediaFile.file, displayInfo.Position)
+	}
+	if mediaFile.err != nil {
+		displayInfo.ChapterTitle = "Can't play"
+		displayInfo.Unplayable = true
+	}
+
+	if app.showQueue {
+		displayInfo.ListTitle = fmt.Sprintf("Queue (%d)", app.queue.len())
+		for _, item := range app.queue.items {
+			displayInfo.List = append(displayInfo.List, app.queueItemName(item))
+		}
 	}
 
-	app.display.Draw(displayInfo)
-	DrawConsole(displayInfo)
+	app.events.Publish(DisplayEvent{Info: displayInfo})
 }
 
 func (app *App) displayMessage(message string) {
-	app.display.Draw(DisplayInfo{
-		artist: message,
-	})
+	app.events.Publish(DisplayEvent{Info: DisplayInfo{
+		Artist: message,
+	}})
 }
 
-func (app *App) run() {
-	CreateConsole(app.buttonEvents)
-	CreateRemote(app.buttonEvents)
+// Subscribe returns a subscription to state changes. Subscribers that don't
+// keep up miss events, but never hold up playback.
+func (app *App) Subscribe(bufferSize int) *Subscription {
+	return app.events.Subscribe(bufferSize)
+}
+
+// The display and console are subscribers like any other front-end. Returns
+// a function that unsubscribes, and waits until draw is no longer called.
+func (app *App) subscribeDrawer(draw func(info DisplayInfo)) func() {
+	var drawing sync.WaitGroup
+	drawing.Add(1)
+	events := app.Subscribe(4)
+	go func() {
+		defer drawing.Done()
+		for event := range events.Events {
+			if event, ok := event.(DisplayEvent); ok {
+				draw(event.Info)
+			}
+		}
+	}()
+	return func() {
+		events.Unsubscribe()
+		drawing.Wait()
+	}
+}
 
-	sourceDirs := []string{"/media", "./media"}
+type consoleFrontend struct {
+	unsubscribe func()
+}
+
+func (c *consoleFrontend) Start(app *App, buttonEvents chan<- Button) {
+	CreateConsole(buttonEvents)
+	c.unsubscribe = app.subscribeDrawer(DrawConsole)
+}
+
+func (c *consoleFrontend) Stop() {
+	c.unsubscribe()
+	DestroyConsole()
+}
+
+type remoteFrontend struct{}
+
+func (remoteFrontend) Start(app *App, buttonEvents chan<- Button) {
+	CreateRemote(buttonEvents)
+}
+
+func (remoteFrontend) Stop() {
+	DestroyRemote()
+}
+
+// Publishes what changed since the last call
+func (app *App) publishChanges() {
+	if len(app.mediaFiles) == 0 {
+		return
+	}
+	if app.playerState != app.publishedState {
+		app.publishedState = app.playerState
+		app.events.Publish(StateEvent{State: app.playerState})
+	}
+	mediaFile := app.currentFile()
+	chapter, chapterIndex, ok := findChapter(mediaFile, app.currentPosition)
+	if !ok {
+		chapter = Chapter{start: 0, end: mediaFile.duration}
+	}
+	if app.currentFileIndex != app.publishedFile {
+		app.publishedFile = app.currentFileIndex
+		app.publishedChapter = -2
+		app.events.Publish(TrackEvent{
+			Album:    mediaFile.file,
+			Title:    mediaFile.title,
+			Artist:   mediaFile.artist,
+			Duration: mediaFile.duration,
+		})
+	}
+	if chapterIndex != app.publishedChapter {
+		app.publishedChapter = chapterIndex
+		app.events.Publish(ChapterEvent{Index: chapterIndex, Title: chapter.title, Start: chapter.start, End: chapter.end})
+	}
+	if app.currentPosition != app.publishedPosition {
+		app.publishedPosition = app.currentPosition
+		app.events.Publish(PositionEvent{Position: app.currentPosition})
+	}
+}
+
+func (app *App) run() {
+	for _, frontend := range app.frontends {
+		frontend.Start(app, app.buttonEvents)
+	}
+	unsubscribeDisplay := func() {}
+	if app.display != nil {
+		unsubscribeDisplay = app.subscribeDrawer(app.display.Draw)
+	}
 
 	app.displayMessage("Loading media ...")
 
+	var signalEvents chan os.Signal
+	if app.handleSignals {
+		signalEvents = make(chan os.Signal, 2)
+		signal.Notify(signalEvents, os.Interrupt, os.Kill, syscall.SIGTERM)
+		defer signal.Stop(signalEvents)
+	}
+
+	app.loadMedia()
+	app.scheduleAlarm()
+
+outerLoop:
+	for {
+		app.publishChanges()
+		app.updateDisplay()
+		select {
+		case button := <-app.buttonEvents:
+			if !app.handleButton(button) {
+				break outerLoop
+			}
+
+		case request := <-app.requests:
+			request.result <- request.f()
+
+		case event := <-app.pipeline.events:
+			app.handlePipelineEvent(event)
+
+		case <-signalEvents:
+			break outerLoop
+
+		case <-app.closing:
+			break outerLoop
+
+		case <-app.alarmEvents:
+			app.handleAlarm()
+
+		case <-app.overlayTimeout:
+			app.hideOverlay()
+
+		case <-app.rescanEvents:
+			app.loadMedia()
+
+		case <-app.numberEntryTimeout:
+			app.finishNumberEntry()
+
+		case <-app.volumeSaveTimeout:
+			app.saveVolume()
+		}
+	}
+
+	if app.volumeSaveTimeout != nil {
+		app.saveVolume()
+	}
+	app.stopListening(false)
+	close(app.stopped)
+	log.Printf("Stopping audio ...")
+	app.pipeline.close()
+	log.Printf("Stopping display ...")
+	unsubscribeDisplay()
+	if app.display != nil {
+		app.display.Stop()
+	}
+	log.Printf("Stopping front-ends ...")
+	for _, frontend := range app.frontends {
+		frontend.Stop()
+	}
+	log.Printf("Sending done signal ...")
+	close(app.finished)
+}
+
+func (app *App) loadMedia() {
+	sourceDirs := app.config.MediaDirs
 	log.Printf("Scanning dirs %v\n", sourceDirs)
 	app.mediaFiles = GetMedia(sourceDirs)
+	if app.kidMode != nil {
+		allowed := []*MediaFile{}
+		for _, mediaFile := range app.mediaFiles {
+			if app.kidMode.allowsAlbum(mediaFile) {
+				allowed = append(allowed, mediaFile)
+			}
+		}
+		app.mediaFiles = allowed
+	}
 	app.mediaFilesByFile = map[string]mediaFileAndIndex{}
 	for i, mediaFile := range app.mediaFiles {
 		log.Printf("Found file: %s (%d chapters)\n", mediaFile.file, len(mediaFile.chapters))
@@ -145,77 +563,93 @@ func (app *App) run() {
 		}
 	}
 
-	signalEvents := make(chan os.Signal, 2)
-	signal.Notify(signalEvents, os.Interrupt, os.Kill, syscall.SIGTERM)
-
+	if len(app.mediaFiles) == 0 {
+		log.Printf("No media found")
+		app.events.Publish(LibraryEvent{Albums: 0})
+		app.rescanEvents = app.clock.After(mediaRescanInterval)
+		return
+	}
+	app.rescanEvents = nil
+	app.events.Publish(LibraryEvent{Albums: len(app.mediaFiles)})
 	app.setFile(0, time.Duration(0))
+}
 
-outerLoop:
-	for {
-		app.updateDisplay()
-		switch app.playerState {
-		case Stopped:
-			select {
-			case button := <-app.buttonEvents:
-				if !app.handleButton(button) {
-					break outerLoop
-				}
-
-			case <-signalEvents:
-				break outerLoop
-			}
-		case Playing:
-			select {
-			case button := <-app.buttonEvents:
-				if !app.handleButton(button) {
-					break outerLoop
-				}
-
-			case <-signalEvents:
-				break outerLoop
-			default:
-				var frame *ffmpeg.AudioFrame
-				var err error
-				if app.passthrough {
-					frame, err = app.decoder.ReadAudioPacket()
-				} else {
-					frame, err = app.decoder.ReadAudioFrame()
-				}
-				if err != nil {
-					log.Printf("ERROR: %v", err)
-				}
-				if frame == nil {
-					// Song finished
-					app.playerState = Stopped
-					app.stopAudioPlayer()
-					app.currentPosition = time.Duration(0)
-					continue
-				}
-				// To avoid glitches while seeking
-				if frame.Position > app.currentPosition {
-					app.currentPosition = frame.Position
-				}
-				if err := app.audioPlayer.Write(frame.Data); err != nil {
-					log.Printf("ERROR: %v", err)
-				}
+func (app *App) handlePipelineEvent(event pipelineEvent) {
+	if event.kind == deviceEvent {
+		app.handleDeviceEvent(event.err)
+		return
+	}
+	if event.generation != app.generation {
+		// Left over from before the last seek
+		return
+	}
+	switch event.kind {
+	case positionEvent:
+		// The first position after a seek can still be just outside the target
+		// chapter, so don't skip on it
+		settled := !app.seeking
+		app.currentPosition = event.position
+		app.seeking = false
+		app.enforceKidMode()
+		app.updateVolume()
+		app.updateListening()
+		// Skip the other chapters of albums with favourite chapters
+		if app.savedState.FavouritesOnly && app.playerState == Playing && settled && app.hasFavourites(app.currentFile()) && !app.isCurrentFavourite() {
+			app.advanceChapter(1)
+		}
+		if item := app.queueItem; item != nil && item.Chapter >= 0 && item.Chapter < len(app.currentFile().chapters) && app.currentPosition >= app.currentFile().chapters[item.Chapter].end {
+			if !app.playNextQueued() {
+				app.queueItem = nil
 			}
 		}
+	case endEvent:
+		app.stopListening(true)
+		if app.playNextQueued() {
+			return
+		}
+		// Song finished
+		app.playerState = Stopped
+		app.stopAudioPlayer()
+		app.setFile(app.currentFileIndex, time.Duration(0))
+	case streamsEvent:
+		app.audioStreams = event.streams
+		app.audioStream = event.audioStream
+		app.conversion = event.conversion
+		app.events.Publish(OutputEvent{Conversion: event.conversion})
+	case errorEvent:
+		mediaFile := app.currentFile()
+		mediaFile.err = event.err
+		app.events.Publish(ErrorEvent{Album: mediaFile.file, Err: event.err})
+		app.setOverlay(&Overlay{Icon: "\u26A0", Text: "Can't play " + filepath.Base(mediaFile.file), Level: -1})
+		app.advanceFile(app.direction, true)
 	}
+}
 
-	log.Printf("Stopping display ...")
-	app.display.Stop()
-	log.Printf("Stopping console ...")
-	DestroyConsole()
-	log.Printf("Sending done signal ...")
-	app.done <- true
-	log.Printf("Sent done signal ...")
+func (app *App) handleDeviceEvent(err error) {
+	app.deviceErr = err
+	if err != nil {
+		app.events.Publish(ErrorEvent{Err: err})
+		return
+	}
+	// Resume where the device failed. The pipeline dropped everything after
+	// that.
+	app.showOverlay("Audio device back")
+	app.generation = app.pipeline.seek(app.currentPosition)
+	app.seeking = true
 }
 
 func (app *App) handleButton(button Button) bool {
 	log.Printf("Button: %#v\n", button)
-	switch button {
-	case PowerButton:
+	if button == PowerButton {
 		return false
+	}
+	if len(app.mediaFiles) == 0 {
+		return true
+	}
+	if app.handleNumberEntryButton(button) {
+		return true
+	}
+	switch button {
 	case NextAlbumButton:
 		app.advanceFile(1, true)
 	case PreviousAlbumButton:
@@ -230,29 +664,342 @@ func (app *App) handleButton(button Button) bool {
 			app.playerState = Stopped
 			app.stopAudioPlayer()
 		case Stopped:
-			app.playerState = Playing
-			app.startAudioPlayer()
+			app.startPlaying()
 		}
+	case AButton, BButton:
+		app.setLoopPoint(button)
+	case SpeedButton:
+		app.cycleSpeed()
+	case QueueButton:
+		app.showQueue = !app.showQueue
+	case AudioTrackButton:
+		app.cycleAudioStream()
+	case FavouriteButton:
+		app.toggleFavourite()
+	case FavouritesOnlyButton:
+		app.toggleFavouritesOnly()
+	case VolumeUpButton:
+		app.changeVolume(volumeStep)
+	case VolumeDownButton:
+		app.changeVolume(-volumeStep)
+	case MuteButton:
+		app.muted = !app.muted
+		app.updateVolume()
+		app.showVolumeOverlay()
+	case ShowVolumeButton:
+		app.showVolumeOverlay()
 	}
 	return true
 }
 
+func (app *App) showOverlay(text string) {
+	app.setOverlay(&Overlay{Text: text, Level: -1})
+}
+
+func (app *App) setOverlay(overlay *Overlay) {
+	app.overlay = overlay
+	app.overlayTimeout = app.clock.After(2 * time.Second)
+}
+
+func (app *App) hideOverlay() {
+	app.overlay = nil
+	app.overlayTimeout = nil
+}
+
+const volumeStep = 0.05
+
+// Time without volume changes after which the volume is saved
+const volumeSaveDelay = time.Second
+
+func (app *App) changeVolume(delta float64) {
+	maxVolume := 1.0
+	if app.kidMode != nil {
+		maxVolume = app.kidMode.maxVolume
+	}
+	volume := math.Max(0, math.Min(maxVolume, app.savedState.Volume+delta))
+	app.muted = false
+	app.savedState.Volume = volume
+	// Keys repeat while being held, so only save once they are released
+	app.volumeSaveTimeout = app.clock.After(volumeSaveDelay)
+	app.updateVolume()
+	app.showVolumeOverlay()
+}
+
+func (app *App) saveVolume() {
+	app.volumeSaveTimeout = nil
+	app.savedState.save()
+}
+
+func (app *App) showVolumeOverlay() {
+	if app.muted {
+		app.setOverlay(&Overlay{Icon: "\U0001F507", Text: "Muted", Level: -1})
+	} else {
+		app.setOverlay(&Overlay{Icon: "\U0001F50A", Level: app.savedState.Volume})
+	}
+}
+
+// Applies the volume to the audio player, taking into account mute and
+// alarm volume ramps.
+func (app *App) updateVolume() {
+	volume := app.savedState.Volume
+	if app.kidMode != nil {
+		volume = math.Min(volume, app.kidMode.maxVolume)
+	}
+	volume *= app.rampGain() * app.fadeGain()
+	if app.muted {
+		volume = 0
+	}
+	volume = math.Floor(volume*100) / 100
+	if volume != app.appliedVolume {
+		app.pipeline.setVolume(volume)
+		app.appliedVolume = volume
+	}
+}
+
+var speeds = []float64{1, 1.25, 1.5, 2, 0.75}
+
+func (app *App) cycleSpeed() {
+	speed := app.speed()
+	nextSpeed := speeds[0]
+	for i, s := range speeds {
+		if s == speed {
+			nextSpeed = speeds[(i+1)%len(speeds)]
+		}
+	}
+	app.setSpeed(nextSpeed)
+}
+
+// Playback speed of the current album
+func (app *App) speed() float64 {
+	if speed, ok := app.savedState.Speeds[app.currentFile().file]; ok {
+		return speed
+	}
+	return 1
+}
+
+func (app *App) setSpeed(speed float64) {
+	file := app.currentFile().file
+	if speed == 1 {
+		delete(app.savedState.Speeds, file)
+	} else {
+		app.savedState.Speeds[file] = speed
+	}
+	app.savedState.save()
+
+	app.generation = app.pipeline.setTempo(speed, app.currentPosition)
+	app.seeking = true
+	app.showOverlay(fmt.Sprintf("Speed %gx", speed))
+}
+
+func (app *App) cycleAudioStream() {
+	if len(app.audioStreams) < 2 {
+		app.showOverlay("No other tracks")
+		return
+	}
+	next := 0
+	for i, stream := range app.audioStreams {
+		if stream.Index == app.audioStream {
+			next = (i + 1) % len(app.audioStreams)
+		}
+	}
+	stream := app.audioStreams[next]
+	app.savedState.AudioStreams[app.currentFile().file] = stream.Index
+	app.savedState.save()
+
+	app.audioStream = stream.Index
+	app.generation = app.pipeline.setAudioStream(stream.Index, app.currentPosition)
+	app.seeking = true
+
+	name := fmt.Sprintf("Track %d/%d", next+1, len(app.audioStreams))
+	for _, s := range []string{stream.Language, stream.Title} {
+		if len(s) > 0 {
+			name += " " + s
+		}
+	}
+	app.showOverlay(name)
+}
+
+// Selected audio stream of the current album, or -1 to select one by
+// language
+func (app *App) selectedAudioStream() int {
+	if audioStream, ok := app.savedState.AudioStreams[app.currentFile().file]; ok {
+		return audioStream
+	}
+	return -1
+}
+
+// Sets the start (A) or the end (B) of the loop. Pressing A again after the
+// start also sets the end, for remotes without a B button. Pressing either
+// button while looping clears the loop.
+func (app *App) setLoopPoint(button Button) {
+	if app.loopEnd >= 0 {
+		app.clearLoop()
+		app.showOverlay("Loop off")
+		return
+	}
+	hasStart := app.loopStart >= 0 && app.currentPosition > app.loopStart
+	switch {
+	case button == AButton && !hasStart:
+		app.loopStart = app.currentPosition
+		app.showOverlay("Loop start")
+	case hasStart:
+		app.loopEnd = app.currentPosition
+		app.generation = app.pipeline.setLoop(app.loopStart, app.loopEnd)
+		app.currentPosition = app.loopStart
+		app.seeking = true
+		app.showOverlay("Loop")
+	}
+}
+
+func (app *App) clearLoop() {
+	if app.loopEnd >= 0 {
+		app.pipeline.clearLoop()
+	}
+	app.loopStart = -1
+	app.loopEnd = -1
+}
+
+func (app *App) scheduleAlarm() {
+	app.alarmEvents, app.nextAlarm, app.nextAlarmRule = app.scheduler.Wait()
+	if app.nextAlarmRule != nil {
+		log.Printf("Next alarm: %v", app.nextAlarm)
+	}
+}
+
+func (app *App) handleAlarm() {
+	rule := app.nextAlarmRule
+	app.scheduleAlarm()
+	if rule == nil {
+		return
+	}
+	log.Printf("Alarm: %#v", *rule)
+	if app.playerState == Playing || len(app.mediaFiles) == 0 {
+		return
+	}
+
+	if len(rule.album) > 0 {
+		if index, ok := app.findAlbum(rule.album); ok {
+			app.setFile(index, time.Duration(0))
+		} else {
+			log.Printf("Alarm album not found: %s", rule.album)
+		}
+	}
+	app.rampStart = app.clock.Now()
+	app.rampDuration = rule.ramp
+	if err := app.startPlaying(); err != nil {
+		log.Printf("Alarm not allowed: %v", err)
+	}
+}
+
+// Fraction of the volume to apply while the volume is being ramped up after
+// an alarm
+func (app *App) rampGain() float64 {
+	if app.rampDuration <= 0 {
+		return 1
+	}
+	elapsed := app.clock.Now().Sub(app.rampStart)
+	if elapsed >= app.rampDuration {
+		app.rampDuration = 0
+		return 1
+	}
+	return float64(elapsed) / float64(app.rampDuration)
+}
+
+func (app *App) findAlbum(album string) (int, bool) {
+	for i, mediaFile := range app.mediaFiles {
+		if albumMatches(mediaFile, []string{album}) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Starts the next item from the queue. Returns false if the queue is empty.
+func (app *App) playNextQueued() bool {
+	for {
+		item, ok := app.queue.pop()
+		if !ok {
+			return false
+		}
+		mediaFile, ok := app.mediaFilesByFile[item.Album]
+		if !ok {
+			log.Printf("Skipping queued album that is no longer available: %s", item.Album)
+			continue
+		}
+		if item.Chapter >= len(mediaFile.file.chapters) {
+			log.Printf("Skipping queued chapter that is no longer available: %s #%d", item.Album, item.Chapter+1)
+			continue
+		}
+		position := time.Duration(0)
+		if item.Chapter >= 0 {
+			position = mediaFile.file.chapters[item.Chapter].start
+		}
+		app.setFile(mediaFile.index, position)
+		app.queueItem = &item
+		return true
+	}
+}
+
+func (app *App) queueItemName(item QueueItem) string {
+	mediaFile, ok := app.mediaFilesByFile[item.Album]
+	if !ok {
+		return filepath.Base(item.Album)
+	}
+	name := mediaFile.file.title
+	if len(name) == 0 {
+		name = filepath.Base(item.Album)
+	}
+	if item.Chapter >= 0 {
+		return fmt.Sprintf("%s #%d", name, item.Chapter+1)
+	}
+	return name
+}
+
 func (app *App) advanceFile(n int, firstChapter bool) {
-	mediaFileIndex := (app.currentFileIndex + len(app.mediaFiles) + n) % len(app.mediaFiles)
+	// The queue overrides the library order
+	if n > 0 && app.playNextQueued() {
+		return
+	}
+	app.queueItem = nil
+	if n != 0 {
+		app.direction = n
+	}
+	mediaFileIndex := app.currentFileIndex
+	for i := 0; i < len(app.mediaFiles); i++ {
+		mediaFileIndex = (mediaFileIndex + len(app.mediaFiles) + n) % len(app.mediaFiles)
+		if app.canAdvanceTo(app.mediaFiles[mediaFileIndex]) {
+			break
+		}
+	}
 	mediaFile := app.mediaFiles[mediaFileIndex]
+	if mediaFile.err != nil {
+		log.Printf("No playable media")
+		app.playerState = Stopped
+		app.stopAudioPlayer()
+	}
 	position := time.Duration(0)
-	if !firstChapter && n < 0 && len(mediaFile.chapters) > 0 {
+	if start, ok := app.favouriteStart(mediaFile, !firstChapter && n < 0); ok {
+		position = start
+	} else if !firstChapter && n < 0 && len(mediaFile.chapters) > 0 {
 		position = mediaFile.chapters[len(mediaFile.chapters)-1].start
 	}
 	app.setFile(mediaFileIndex, position)
 }
 
 func (app *App) advanceChapter(n int) {
+	if item := app.queueItem; item != nil && item.Chapter >= 0 {
+		if n > 0 && app.playNextQueued() {
+			return
+		}
+		app.queueItem = nil
+	}
 	currentFile := app.currentFile()
 	if chapter, chapterIndex, ok := findChapter(currentFile, app.currentPosition); ok {
 		nextChapter := chapterIndex + n
 		if n < 0 && app.currentPosition > chapter.start+(3*time.Second) {
 			nextChapter = chapterIndex
+		} else if favourite, restricted := app.nextFavouriteChapter(chapterIndex, n); restricted {
+			nextChapter = favourite
 		}
 		if nextChapter < 0 || nextChapter >= len(currentFile.chapters) {
 			app.advanceFile(n, false)
@@ -264,56 +1011,69 @@ func (app *App) advanceChapter(n int) {
 	}
 }
 
+// Starts a new history entry when playback moves on to another chapter
+func (app *App) updateListening() {
+	_, chapterIndex, _ := findChapter(app.currentFile(), app.currentPosition)
+	if entry := app.listening; entry != nil && !app.seeking && (entry.Album != app.currentFile().file || entry.Chapter != chapterIndex) {
+		app.stopListening(entry.Album == app.currentFile().file && chapterIndex > entry.Chapter)
+	}
+	if app.listening == nil && app.playerState == Playing {
+		app.listening = &history.Entry{
+			Start:   app.clock.Now(),
+			Album:   app.currentFile().file,
+			Chapter: chapterIndex,
+		}
+	}
+}
+
+// Writes the current history entry to the log
+func (app *App) stopListening(finished bool) {
+	entry := app.listening
+	if entry == nil {
+		return
+	}
+	app.listening = nil
+	entry.Listened = app.clock.Now().Sub(entry.Start)
+	entry.Finished = finished
+	if err := history.Append(filepath.Join(app.config.StateDir, "history.tsv"), *entry); err != nil {
+		log.Printf("Error saving history: %v", err)
+	}
+}
+
 func (app *App) currentFile() *MediaFile {
 	return app.mediaFiles[app.currentFileIndex]
 }
 
 func (app *App) startAudioPlayer() {
-	codec, codecProfile := app.decoder.Codec()
-	app.passthrough = audioplayer.IsPassthroughSupported(codec, codecProfile, app.decoder.SampleRate())
-	encoding := audioplayer.PCMEncoding
-	if app.passthrough {
-		encoding = codec
-	}
-	err := app.audioPlayer.Start(app.decoder.NumChannels(), app.decoder.BytesPerSample(), app.decoder.SampleRate(), app.decoder.IsFloatPlanar(), encoding)
-	if err != nil {
-		log.Printf("ERROR: %v", err)
-	}
+	app.updateVolume()
+	app.pipeline.play()
 }
 
 func (app *App) stopAudioPlayer() {
-	app.audioPlayer.Stop()
+	app.stopListening(false)
+	app.resetKidMode()
+	app.pipeline.pause()
 }
 
 func (app *App) setFile(index int, position time.Duration) {
-	startPlayer := false
 	fileChanged := app.currentFileIndex != index
 	positionChanged := (fileChanged && position != 0) || (!fileChanged && app.currentPosition != position)
 
 	app.currentFileIndex = index
 	app.currentPosition = position
+	app.seeking = fileChanged || positionChanged
 
-	if fileChanged {
-		if app.playerState == Playing {
-			app.stopAudioPlayer()
-			startPlayer = true
-		}
-		if app.decoder != nil {
-			app.decoder.Close()
-		}
-		log.Printf("Opening %s", app.currentFile().file)
-		decoder, err := ffmpeg.Create(app.currentFile().file, app.audioPlayer.NumOutputChannels())
-		if err != nil {
-			log.Printf("ERROR: %v", err)
-		}
-		app.decoder = decoder
-	}
-
-	if positionChanged {
-		app.decoder.Seek(position)
+	if fileChanged || positionChanged {
+		app.stopListening(false)
 	}
-
-	if startPlayer {
-		app.startAudioPlayer()
+	if fileChanged {
+		// Opening a file already clears the loop in the pipeline
+		app.loopStart = -1
+		app.loopEnd = -1
+		app.audioStreams = nil
+		app.generation = app.pipeline.open(app.currentFile().file, position, app.speed(), app.selectedAudioStream())
+	} else if positionChanged {
+		app.clearLoop()
+		app.generation = app.pipeline.seek(position)
 	}
 }
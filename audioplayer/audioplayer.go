@@ -1,10 +1,55 @@
 package audioplayer
 
+import (
+	"time"
+)
+
 type AudioPlayer interface {
-	Start(numChannels int, bytesPerSample int, sampleRate int, isFloatPlanar bool, encoding string) error
+	// Starts playing interleaved samples in one of SampleFormats, or
+	// undecoded packets if encoding isn't PCMEncoding. Returns a *FormatError
+	// if the output doesn't take the format, as opposed to failing.
+	Start(numChannels int, sampleFormat string, sampleRate int, encoding string) error
 	Stop()
+	// Outputs take any layout of up to this many channels, as long as the
+	// channels come in the order of ffmpeg.Options.ChannelOrder, so the number
+	// of channels is all that the decoder needs to know about layouts.
 	NumOutputChannels() int
+	// Sample formats that the output accepts, named as in FFmpeg (e.g. "s16",
+	// "flt"), with "s24" for packed 24-bit samples. Each of them works at
+	// each of SampleRates. These can change after a Reset.
+	SampleFormats() []string
+	// Sample rates that the output accepts, or nil if it takes any rate
+	SampleRates() []int
 	Write(data []byte) error
+	// Volume between 0 and 1
+	SetVolume(volume float64)
+	// Time before the last written sample is heard
+	Latency() time.Duration
+	// Closes and reopens the output device after it failed. Start has to be
+	// called again afterwards.
+	Reset() error
 }
 
 const PCMEncoding = "pcm"
+
+// FormatError is returned by Start when the output doesn't take the format
+type FormatError struct {
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	return e.Err.Error()
+}
+
+func BytesPerSample(sampleFormat string) int {
+	switch sampleFormat {
+	case "u8":
+		return 1
+	case "s16":
+		return 2
+	case "s24":
+		return 3
+	default:
+		return 4
+	}
+}
@@ -48,7 +48,7 @@ void printPCM(COMPONENT_T* comp, int portIndex) {
 }
 
 
-OMXClient* OMXClient_Create() {
+OMXClient* OMXClient_Create(const char* destination) {
   OMX_ERRORTYPE omxErr;
 
   OMXClient* client = malloc(sizeof(OMXClient));
@@ -72,9 +72,35 @@ OMXClient* OMXClient_Create() {
 
   set_tunnel(&client->tunnel, client->decoder, 121, client->renderer, 100);
 
+  client->running = 0;
+  client->volume = 0;
+  strncpy(client->destination, destination, sizeof(client->destination) - 1);
+  client->destination[sizeof(client->destination) - 1] = '\0';
+
   return client;
 }
 
+void applyVolume(OMXClient* client) {
+  OMX_AUDIO_CONFIG_VOLUMETYPE volume;
+  memset(&volume, 0, sizeof(volume));
+  volume.nSize = sizeof(OMX_AUDIO_CONFIG_VOLUMETYPE);
+  volume.nVersion.nVersion = OMX_VERSION;
+  volume.nPortIndex = 100;
+  volume.bLinear = OMX_FALSE;
+  volume.sVolume.nValue = client->volume;
+  OMX_ERRORTYPE omxErr = OMX_SetConfig(ILC_GET_HANDLE(client->renderer), OMX_IndexConfigAudioVolume, &volume);
+  if (omxErr != OMX_ErrorNone) {
+    fprintf(stderr, "omx error setting volume: %x\n", omxErr);
+  }
+}
+
+void OMXClient_SetVolume(OMXClient* client, int millibels) {
+  client->volume = millibels;
+  if (client->running) {
+    applyVolume(client);
+  }
+}
+
 void setupDecoderRendererTunnel(OMXClient* client) {
   int err = ilclient_setup_tunnel(&client->tunnel, 0, 0);
   assert(err == 0);
@@ -185,7 +211,7 @@ int OMXClient_Start(OMXClient* client, int numChannels, int bitDepth, int sample
   memset(&destination, 0, sizeof(destination));
   destination.nSize = sizeof(OMX_CONFIG_BRCMAUDIODESTINATIONTYPE);
   destination.nVersion.nVersion = OMX_VERSION;
-  strcpy((char *)destination.sName, "hdmi");
+  strcpy((char *)destination.sName, client->destination);
   omxErr = OMX_SetConfig(ILC_GET_HANDLE(client->renderer), OMX_IndexConfigBrcmAudioDestination, &destination);
   assert(omxErr == OMX_ErrorNone);
 
@@ -193,10 +219,31 @@ int OMXClient_Start(OMXClient* client, int numChannels, int bitDepth, int sample
 
   setupDecoderRendererTunnel(client);
 
+  client->running = 1;
+  applyVolume(client);
+
   return 0;
 }
 
+// Returns the number of samples queued in the renderer
+int OMXClient_GetLatency(OMXClient* client) {
+  if (!client->running) {
+    return 0;
+  }
+  OMX_PARAM_U32TYPE latency;
+  memset(&latency, 0, sizeof(latency));
+  latency.nSize = sizeof(OMX_PARAM_U32TYPE);
+  latency.nVersion.nVersion = OMX_VERSION;
+  latency.nPortIndex = 100;
+  OMX_ERRORTYPE omxErr = OMX_GetConfig(ILC_GET_HANDLE(client->renderer), OMX_IndexConfigAudioRenderingLatency, &latency);
+  if (omxErr != OMX_ErrorNone) {
+    return 0;
+  }
+  return latency.nU32;
+}
+
 void OMXClient_Stop(OMXClient* client) {
+  client->running = 0;
   ilclient_disable_tunnel(&client->tunnel);
   ilclient_change_component_state(client->decoder, OMX_StateIdle);
 
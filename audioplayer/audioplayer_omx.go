@@ -8,6 +8,7 @@ package audioplayer
 #cgo LDFLAGS: -L/opt/vc/lib/ -lvcos -lvchiq_arm -lpthread -lopenmaxil -L/opt/vc/src/hello_pi/libs/ilclient -lilclient
 
 #include <bcm_host.h>
+#include <stdlib.h>
 
 #include "audioplayer_omx.h"
 
@@ -16,34 +17,78 @@ import "C"
 
 import (
 	"fmt"
+	"log"
 	"sync"
+	"time"
 	"unsafe"
 )
 
 var omxInitializeOnce sync.Once
 
 type OMXAudioPlayer struct {
-	client *C.OMXClient
+	// Nil while the renderer can't be opened
+	client     *C.OMXClient
+	device     string
+	sampleRate int
 }
 
-func Create() (*OMXAudioPlayer, error) {
+// Creates a player for the given audio destination ("hdmi" or "local").
+// Defaults to HDMI. A renderer that can't be opened isn't fatal: Start fails
+// until a Reset opens it.
+func Create(device string) (AudioPlayer, error) {
 	omxInitializeOnce.Do(func() {
 		C.bcm_host_init()
 	})
 
-	client := C.OMXClient_Create()
-	if client == nil {
-		return nil, fmt.Errorf("createOMXClient")
+	if len(device) == 0 {
+		device = "hdmi"
 	}
-	return &OMXAudioPlayer{
-		client: client,
-	}, nil
+	p := &OMXAudioPlayer{device: device}
+	if err := p.createClient(); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	return p, nil
+}
+
+func (p *OMXAudioPlayer) createClient() error {
+	cDevice := C.CString(p.device)
+	defer C.free(unsafe.Pointer(cDevice))
+	p.client = C.OMXClient_Create(cDevice)
+	if p.client == nil {
+		return fmt.Errorf("createOMXClient")
+	}
+	return nil
+}
+
+func (p *OMXAudioPlayer) Reset() error {
+	if p.client != nil {
+		if p.client.running != 0 {
+			C.OMXClient_Stop(p.client)
+		}
+		volume := p.client.volume
+		C.OMXClient_Destroy(p.client)
+		p.client = nil
+		if err := p.createClient(); err != nil {
+			return err
+		}
+		p.client.volume = volume
+		return nil
+	}
+	return p.createClient()
 }
 
-func (p *OMXAudioPlayer) Start(numChannels int, bytesPerSample int, sampleRate int, isFloatPlanar bool, encoding string) error {
+func (p *OMXAudioPlayer) Start(numChannels int, sampleFormat string, sampleRate int, encoding string) error {
+	if p.client == nil {
+		return fmt.Errorf("No output device")
+	}
+	bytesPerSample := BytesPerSample(sampleFormat)
 	cIsFloatPlanar := 0
-	if isFloatPlanar {
+	switch sampleFormat {
+	case "s16", "s32":
+	case "fltp":
 		cIsFloatPlanar = 1
+	default:
+		return &FormatError{fmt.Errorf("Unsupported sample format: %s", sampleFormat)}
 	}
 	var cEncoding C.OMXClientEncoding
 	switch encoding {
@@ -57,10 +102,14 @@ func (p *OMXAudioPlayer) Start(numChannels int, bytesPerSample int, sampleRate i
 	if ret := C.OMXClient_Start(p.client, C.int(numChannels), C.int(bytesPerSample<<3), C.int(sampleRate), C.int(cIsFloatPlanar), cEncoding); ret != 0 {
 		return fmt.Errorf("error start")
 	}
+	p.sampleRate = sampleRate
 	return nil
 }
 
 func (p *OMXAudioPlayer) Stop() {
+	if p.client == nil {
+		return
+	}
 	C.OMXClient_Stop(p.client)
 }
 
@@ -68,7 +117,38 @@ func (p *OMXAudioPlayer) NumOutputChannels() int {
 	return 8
 }
 
+func (p *OMXAudioPlayer) SampleFormats() []string {
+	return []string{"s16", "s32"}
+}
+
+// The renderer resamples to what the destination needs
+func (p *OMXAudioPlayer) SampleRates() []int {
+	return nil
+}
+
+// Without a renderer, the volume is set again after the next Start
+func (p *OMXAudioPlayer) SetVolume(volume float64) {
+	if p.client == nil {
+		return
+	}
+	millibels := -6000
+	if volume > 0 {
+		millibels = int(volumeToDecibels(volume) * 100)
+	}
+	C.OMXClient_SetVolume(p.client, C.int(millibels))
+}
+
+func (p *OMXAudioPlayer) Latency() time.Duration {
+	if p.client == nil || p.sampleRate == 0 {
+		return 0
+	}
+	return time.Duration(int64(C.OMXClient_GetLatency(p.client)) * int64(time.Second) / int64(p.sampleRate))
+}
+
 func (p *OMXAudioPlayer) Write(data []byte) error {
+	if p.client == nil {
+		return fmt.Errorf("Stream not started")
+	}
 	if err := C.OMXClient_Write(p.client, (*C.char)(unsafe.Pointer(&data[0])), C.int(len(data))); err != 0 {
 		return fmt.Errorf("error writing")
 	}
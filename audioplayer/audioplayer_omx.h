@@ -10,6 +10,9 @@ typedef struct {
   COMPONENT_T* renderer;
   TUNNEL_T tunnel;
   int firstFrame;
+  int running;
+  int volume;
+  char destination[16];
 } OMXClient;
 
 typedef enum OMXClientEncoding {
@@ -18,10 +21,12 @@ typedef enum OMXClientEncoding {
   OMXClientEncoding_DDP
 } OMXClientEncoding;
 
-OMXClient* OMXClient_Create();
+OMXClient* OMXClient_Create(const char* destination);
 int OMXClient_Write(OMXClient* client, const char* data, int len);
 int OMXClient_Start(OMXClient* client, int numChannels, int bitsPerSample, int sampleRate, int isFloatPlanar, OMXClientEncoding codec);
 void OMXClient_Stop(OMXClient* client);
+void OMXClient_SetVolume(OMXClient* client, int millibels);
+int OMXClient_GetLatency(OMXClient* client);
 void OMXClient_Destroy(OMXClient* client);
 
 #endif
@@ -11,76 +11,251 @@ import "C"
 import (
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
 )
 
 var initializePA sync.Once
 
 type PortAudioPlayer struct {
+	// Name of the configured device, or empty for the default device
+	deviceName     string
 	device         C.PaDeviceIndex
 	stream         unsafe.Pointer
 	numChannels    int
+	sampleFormat   string
 	bytesPerSample int
+	gain           float64
+	// Result of supportedFormats for the device, once probed
+	sampleFormats []string
+	sampleRates   []int
+	probed        bool
 }
 
-func Create() (*PortAudioPlayer, error) {
+var paSampleFormats = map[string]C.PaSampleFormat{
+	"u8":  C.paUInt8,
+	"s16": C.paInt16,
+	"s24": C.paInt24,
+	"s32": C.paInt32,
+	"flt": C.paFloat32,
+}
+
+// Creates a player for the device whose name contains deviceName, or for the
+// default device if deviceName is empty. A missing device isn't fatal: Start
+// fails until a Reset finds it.
+func Create(deviceName string) (AudioPlayer, error) {
 	initializePA.Do(func() {
 		C.Pa_Initialize()
-		for i := 0; i < int(C.Pa_GetDeviceCount()); i += 1 {
-			deviceInfo := C.Pa_GetDeviceInfo(C.PaDeviceIndex(i))
-			log.Printf("Detected device: %v (%d channels)", C.GoString(deviceInfo.name), int(deviceInfo.maxOutputChannels))
-		}
 		//defer C.Pa_Terminate()
 	})
-	device := C.Pa_GetDefaultOutputDevice()
-	if device == C.paNoDevice {
-		return nil, fmt.Errorf("No output device")
+	p := &PortAudioPlayer{
+		deviceName: deviceName,
+		gain:       1,
+	}
+	if err := p.selectDevice(); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	return p, nil
+}
+
+func (p *PortAudioPlayer) selectDevice() error {
+	p.device = C.paNoDevice
+	for i := 0; i < int(C.Pa_GetDeviceCount()); i += 1 {
+		deviceInfo := C.Pa_GetDeviceInfo(C.PaDeviceIndex(i))
+		name := C.GoString(deviceInfo.name)
+		log.Printf("Detected device: %v (%d channels)", name, int(deviceInfo.maxOutputChannels))
+		if p.device == C.paNoDevice && len(p.deviceName) > 0 && deviceInfo.maxOutputChannels > 0 && strings.Contains(name, p.deviceName) {
+			p.device = C.PaDeviceIndex(i)
+		}
+	}
+	if len(p.deviceName) == 0 {
+		p.device = C.Pa_GetDefaultOutputDevice()
+	}
+	if p.device == C.paNoDevice {
+		return fmt.Errorf("No output device")
 	}
 
-	deviceInfo := C.Pa_GetDeviceInfo(device)
+	deviceInfo := C.Pa_GetDeviceInfo(p.device)
 	log.Printf("Selected Device: %s (%d channels)", C.GoString(deviceInfo.name), int(deviceInfo.maxOutputChannels))
+	return nil
+}
 
-	return &PortAudioPlayer{
-		device: device,
-	}, nil
+// PortAudio only detects devices when it's initialized, so it's restarted to
+// pick up devices that were plugged back in.
+func (p *PortAudioPlayer) Reset() error {
+	p.Stop()
+	p.sampleFormats = nil
+	p.sampleRates = nil
+	p.probed = false
+	C.Pa_Terminate()
+	if err := C.Pa_Initialize(); err != C.paNoError {
+		return paError(err)
+	}
+	return p.selectDevice()
 }
 
 func (p *PortAudioPlayer) Stop() {
 	if p.stream != nil {
 		C.Pa_StopStream(p.stream)
 		C.Pa_CloseStream(p.stream)
+		p.stream = nil
 	}
 }
 
-func (p *PortAudioPlayer) Start(numChannels int, bytesPerSample int, sampleRate int, isFloatPlanar bool, encoding string) error {
+func (p *PortAudioPlayer) Start(numChannels int, sampleFormat string, sampleRate int, encoding string) error {
+	if p.device == C.paNoDevice {
+		return fmt.Errorf("No output device")
+	}
+	paSampleFormat, ok := paSampleFormats[sampleFormat]
+	if !ok {
+		return &FormatError{fmt.Errorf("Unsupported sample format: %s", sampleFormat)}
+	}
 	p.numChannels = numChannels
-	p.bytesPerSample = bytesPerSample
+	p.sampleFormat = sampleFormat
+	p.bytesPerSample = BytesPerSample(sampleFormat)
 	outputParameters := C.PaStreamParameters{
 		device:                    p.device,
 		channelCount:              C.int(numChannels),
+		sampleFormat:              paSampleFormat,
 		suggestedLatency:          0.050,
 		hostApiSpecificStreamInfo: nil,
 	}
-	if bytesPerSample == 2 {
-		outputParameters.sampleFormat = C.paInt16
-	} else {
-		outputParameters.sampleFormat = C.paInt32
-	}
 	if err := C.Pa_OpenStream(&p.stream, nil, &outputParameters, C.double(sampleRate), 0, C.paClipOff, nil, nil); err != C.paNoError {
+		p.stream = nil
+		switch err {
+		case C.paSampleFormatNotSupported, C.paInvalidSampleRate, C.paInvalidChannelCount:
+			return &FormatError{paError(err)}
+		}
 		return paError(err)
 	}
 	if err := C.Pa_StartStream(p.stream); err != C.paNoError {
+		p.Stop()
 		return paError(err)
 	}
 	return nil
 }
 
 func (p *PortAudioPlayer) NumOutputChannels() int {
+	if p.device == C.paNoDevice {
+		return 2
+	}
 	return int(C.Pa_GetDeviceInfo(p.device).maxOutputChannels)
 }
 
+func (p *PortAudioPlayer) SampleFormats() []string {
+	if p.device == C.paNoDevice {
+		return []string{"s16", "s32"}
+	}
+	sampleFormats, _ := p.supportedFormats()
+	log.Printf("Supported sample formats: %v", sampleFormats)
+	return sampleFormats
+}
+
+func (p *PortAudioPlayer) SampleRates() []int {
+	if p.device == C.paNoDevice {
+		return nil
+	}
+	_, rates := p.supportedFormats()
+	log.Printf("Supported sample rates: %v", rates)
+	return rates
+}
+
+// Asks the device which combinations of sample format and common sample rate
+// it takes. The rates are those that work with any format, and the formats
+// those that work at all of these rates, so that any format can be combined
+// with any rate. Host APIs convert between formats, so this mostly tells
+// whether 24-bit and float output work. The device is only asked once, until
+// the next Reset.
+func (p *PortAudioPlayer) supportedFormats() ([]string, []int) {
+	if !p.probed {
+		p.sampleFormats, p.sampleRates = p.probeFormats()
+		p.probed = true
+	}
+	return p.sampleFormats, p.sampleRates
+}
+
+func (p *PortAudioPlayer) probeFormats() ([]string, []int) {
+	deviceInfo := C.Pa_GetDeviceInfo(p.device)
+	numChannels := deviceInfo.maxOutputChannels
+	if numChannels > 2 {
+		numChannels = 2
+	}
+	allFormats := []string{"u8", "s16", "s24", "s32", "flt"}
+	supported := map[string]map[int]bool{}
+	rates := []int{}
+	for _, rate := range []int{44100, 48000, 88200, 96000, 176400, 192000} {
+		rateSupported := false
+		for _, sampleFormat := range allFormats {
+			outputParameters := C.PaStreamParameters{
+				device:           p.device,
+				channelCount:     numChannels,
+				sampleFormat:     paSampleFormats[sampleFormat],
+				suggestedLatency: 0.050,
+			}
+			if C.Pa_IsFormatSupported(nil, &outputParameters, C.double(rate)) == C.paFormatIsSupported {
+				if supported[sampleFormat] == nil {
+					supported[sampleFormat] = map[int]bool{}
+				}
+				supported[sampleFormat][rate] = true
+				rateSupported = true
+			}
+		}
+		if rateSupported {
+			rates = append(rates, rate)
+		}
+	}
+	if len(rates) == 0 {
+		// Let the device try whatever it gets
+		return []string{"s16"}, nil
+	}
+	sampleFormats := []string{}
+	for _, sampleFormat := range allFormats {
+		all := true
+		for _, rate := range rates {
+			all = all && supported[sampleFormat][rate]
+		}
+		if all {
+			sampleFormats = append(sampleFormats, sampleFormat)
+		}
+	}
+	if len(sampleFormats) == 0 {
+		// No format works at all rates, so only use the rates of s16
+		var s16Rates []int
+		for _, rate := range rates {
+			if supported["s16"][rate] {
+				s16Rates = append(s16Rates, rate)
+			}
+		}
+		return []string{"s16"}, s16Rates
+	}
+	return sampleFormats, rates
+}
+
+// PortAudio has no mixer control, so volume is applied in software
+func (p *PortAudioPlayer) SetVolume(volume float64) {
+	p.gain = VolumeGain(volume)
+}
+
+// Writes block until there is room in the stream buffer, so after a write the
+// latency is the full output latency of the stream.
+func (p *PortAudioPlayer) Latency() time.Duration {
+	if p.stream == nil {
+		return 0
+	}
+	info := C.Pa_GetStreamInfo(p.stream)
+	if info == nil {
+		return 0
+	}
+	return time.Duration(float64(info.outputLatency) * float64(time.Second))
+}
+
 func (p *PortAudioPlayer) Write(data []byte) error {
+	if p.stream == nil {
+		return fmt.Errorf("Stream not started")
+	}
+	ApplyGain(data, p.sampleFormat, p.gain)
 	nbSamples := len(data) / (p.numChannels * p.bytesPerSample)
 	if err := C.Pa_WriteStream(p.stream, unsafe.Pointer(&data[0]), C.ulong(nbSamples)); err != C.paNoError {
 		if err == C.paOutputUnderflowed {
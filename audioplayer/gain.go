@@ -0,0 +1,63 @@
+package audioplayer
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Dynamic range covered by the volume control
+const volumeRange = 50.0
+
+// Attenuation (in dB) for a volume between 0 and 1
+func volumeToDecibels(volume float64) float64 {
+	return volumeRange * (volume - 1)
+}
+
+// VolumeGain returns the linear gain for a volume between 0 and 1.
+func VolumeGain(volume float64) float64 {
+	if volume <= 0 {
+		return 0
+	}
+	if volume >= 1 {
+		return 1
+	}
+	return math.Pow(10, volumeToDecibels(volume)/20)
+}
+
+// ApplyGain scales little-endian PCM samples in place.
+func ApplyGain(data []byte, sampleFormat string, gain float64) {
+	if gain >= 1 {
+		return
+	}
+	if gain < 0 {
+		gain = 0
+	}
+	switch sampleFormat {
+	case "u8":
+		for i := range data {
+			data[i] = byte(128 + int(float64(int(data[i])-128)*gain))
+		}
+	case "s16":
+		for i := 0; i+1 < len(data); i += 2 {
+			sample := int16(binary.LittleEndian.Uint16(data[i:]))
+			binary.LittleEndian.PutUint16(data[i:], uint16(int16(float64(sample)*gain)))
+		}
+	case "s24":
+		for i := 0; i+2 < len(data); i += 3 {
+			// Sign-extend through the top byte of an int32
+			sample := int32(uint32(data[i])<<8|uint32(data[i+1])<<16|uint32(data[i+2])<<24) >> 8
+			sample = int32(float64(sample) * gain)
+			data[i], data[i+1], data[i+2] = byte(sample), byte(sample>>8), byte(sample>>16)
+		}
+	case "s32":
+		for i := 0; i+3 < len(data); i += 4 {
+			sample := int32(binary.LittleEndian.Uint32(data[i:]))
+			binary.LittleEndian.PutUint32(data[i:], uint32(int32(float64(sample)*gain)))
+		}
+	case "flt", "fltp":
+		for i := 0; i+3 < len(data); i += 4 {
+			sample := math.Float32frombits(binary.LittleEndian.Uint32(data[i:]))
+			binary.LittleEndian.PutUint32(data[i:], math.Float32bits(float32(float64(sample)*gain)))
+		}
+	}
+}
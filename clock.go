@@ -0,0 +1,80 @@
+package jukybox
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts the wall clock, so that time-based logic (such as alarms)
+// can be driven by a ManualClock instead of waiting for real time to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ManualClock is a Clock that only moves when told to.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualClockWaiter
+}
+
+type manualClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiter := manualClockWaiter{
+		deadline: c.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		waiter.c <- c.now
+	} else {
+		c.waiters = append(c.waiters, waiter)
+	}
+	return waiter.c
+}
+
+// Advance moves the clock forward, firing all channels whose deadline has passed.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	waiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if !now.Before(waiter.deadline) {
+			waiter.c <- now
+		} else {
+			waiters = append(waiters, waiter)
+		}
+	}
+	c.waiters = waiters
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/remko/jukybox/history"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type stats struct {
+	listened time.Duration
+	plays    int
+	finished int
+}
+
+func (s *stats) add(entry history.Entry) {
+	s.listened += entry.Listened
+	s.plays++
+	if entry.Finished {
+		s.finished++
+	}
+}
+
+type chapterKey struct {
+	album   string
+	chapter int
+}
+
+func albumName(album string) string {
+	name := filepath.Base(album)
+	return name[:len(name)-len(filepath.Ext(name))]
+}
+
+func printStats(name string, s *stats) {
+	fmt.Printf("  %-40s %10v %4d plays %4d finished\n", name, s.listened, s.plays, s.finished)
+}
+
+func summarize(file string) error {
+	entries, err := history.Read(file)
+	if err != nil {
+		return err
+	}
+
+	days := map[string]*stats{}
+	albums := map[string]*stats{}
+	chapters := map[chapterKey]*stats{}
+	for _, entry := range entries {
+		day := entry.Start.Format("2006-01-02")
+		if days[day] == nil {
+			days[day] = &stats{}
+		}
+		days[day].add(entry)
+		if albums[entry.Album] == nil {
+			albums[entry.Album] = &stats{}
+		}
+		albums[entry.Album].add(entry)
+		key := chapterKey{entry.Album, entry.Chapter}
+		if chapters[key] == nil {
+			chapters[key] = &stats{}
+		}
+		chapters[key].add(entry)
+	}
+
+	fmt.Printf("By day:\n")
+	dayNames := []string{}
+	for day := range days {
+		dayNames = append(dayNames, day)
+	}
+	sort.Strings(dayNames)
+	for _, day := range dayNames {
+		printStats(day, days[day])
+	}
+
+	fmt.Printf("\nBy album:\n")
+	albumNames := []string{}
+	for album := range albums {
+		albumNames = append(albumNames, album)
+	}
+	sort.Slice(albumNames, func(i, j int) bool {
+		return albums[albumNames[i]].listened > albums[albumNames[j]].listened
+	})
+	for _, album := range albumNames {
+		printStats(albumName(album), albums[album])
+	}
+
+	fmt.Printf("\nBy chapter:\n")
+	chapterKeys := []chapterKey{}
+	for key := range chapters {
+		chapterKeys = append(chapterKeys, key)
+	}
+	sort.Slice(chapterKeys, func(i, j int) bool {
+		if chapterKeys[i].album != chapterKeys[j].album {
+			return albumName(chapterKeys[i].album) < albumName(chapterKeys[j].album)
+		}
+		return chapterKeys[i].chapter < chapterKeys[j].chapter
+	})
+	for _, key := range chapterKeys {
+		name := albumName(key.album)
+		if key.chapter >= 0 {
+			name = fmt.Sprintf("%s #%d", name, key.chapter+1)
+		}
+		printStats(name, chapters[key])
+	}
+	return nil
+}
+
+// Summarizes the history in the given state directory (the stateDir of the
+// jukybox config), or the history file given as argument.
+func main() {
+	stateDir := flag.String("state-dir", "/var/lib/jukybox", "directory where jukybox keeps its state")
+	flag.Parse()
+	file := filepath.Join(*stateDir, "history.tsv")
+	if flag.NArg() > 0 {
+		file = flag.Arg(0)
+	}
+	if err := summarize(file); err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+}
@@ -9,13 +9,17 @@ import (
 )
 
 func play(file string) error {
-	player, err := audioplayer.Create()
+	player, err := audioplayer.Create("")
 	if err != nil {
 		return err
 	}
 	defer player.Stop()
 
-	decoder, err := ffmpeg.Create(file, player.NumOutputChannels())
+	decoder, err := ffmpeg.CreateWithOptions(file, ffmpeg.Options{
+		MaxChannels:   player.NumOutputChannels(),
+		SampleFormats: player.SampleFormats(),
+		SampleRates:   player.SampleRates(),
+	})
 	if err != nil {
 		return err
 	}
@@ -23,13 +27,14 @@ func play(file string) error {
 
 	codec, codecProfile := decoder.Codec()
 	fmt.Printf("Codec: %v (%v)\n", codec, codecProfile)
+	fmt.Printf("Conversion: %v\n", decoder.Conversion())
 	passthrough := audioplayer.IsPassthroughSupported(codec, codecProfile, decoder.SampleRate())
 	encoding := audioplayer.PCMEncoding
 	if passthrough {
 		encoding = codec
 	}
 
-	player.Start(decoder.NumChannels(), decoder.BytesPerSample(), decoder.SampleRate(), decoder.IsFloatPlanar(), encoding)
+	player.Start(decoder.NumChannels(), decoder.SampleFormat(), decoder.SampleRate(), encoding)
 
 	for {
 		var frame *ffmpeg.AudioFrame
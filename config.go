@@ -0,0 +1,101 @@
+package jukybox
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+var configFiles = []string{"/boot/jukybox.json", "./jukybox.json"}
+
+type AlarmConfig struct {
+	// Days on which the alarm goes off: day names ("mon", "tue", ...),
+	// "weekdays", "weekends", or "daily". Defaults to daily.
+	Days []string `json:"days"`
+	// Time of day, as "15:04"
+	Time string `json:"time"`
+	// Title or file name of the album to play. Empty resumes the current album.
+	Album string `json:"album"`
+	// Duration over which the volume is raised, as "2m"
+	Ramp string `json:"ramp"`
+}
+
+// Restrictions for a box in a child's room. If any setting is invalid, only
+// the AnytimeAlbums can be played.
+type KidModeConfig struct {
+	// Maximum volume, between 0 and 1
+	MaxVolume float64 `json:"maxVolume"`
+	// Times of day during which playback is allowed, as "07:00-20:00".
+	// Empty allows playback all day.
+	Hours []string `json:"hours"`
+	// Titles or file names of albums that can be played outside the hours
+	// (e.g. a sleep album)
+	AnytimeAlbums []string `json:"anytimeAlbums"`
+	// Maximum listening time per day, as "2h". Empty means no limit.
+	DailyBudget string `json:"dailyBudget"`
+	// Titles or file names of the albums that can be played. Empty allows all
+	// albums.
+	Albums []string `json:"albums"`
+}
+
+// How surround audio is mixed down when the device has fewer channels
+type DownmixConfig struct {
+	// Mixes everything into a single channel, for a room with one speaker
+	Mono bool `json:"mono"`
+	// Levels (in dB, e.g. -3) of the center, surround and LFE channels in the
+	// mix. Empty uses FFmpeg's defaults.
+	CenterLevel   *float64 `json:"centerLevel"`
+	SurroundLevel *float64 `json:"surroundLevel"`
+	LFELevel      *float64 `json:"lfeLevel"`
+	// Encodes surround into the stereo mix for a matrix decoder: "dolby" or
+	// "dplii" (Dolby Pro Logic II)
+	Matrix string `json:"matrix"`
+}
+
+type Config struct {
+	MediaDirs []string `json:"mediaDirs"`
+	// Audio output device: part of the device name, or "hdmi" or "local" on the
+	// Raspberry Pi. Empty selects the default device.
+	AudioDevice string `json:"audioDevice"`
+	// Preferred languages of the audio track (e.g. "eng"), in order
+	AudioLanguages []string `json:"audioLanguages"`
+	// Order of the speakers that the audio device expects, as FFmpeg channel
+	// names (e.g. ["FL", "FR", "FC", "LFE", "BL", "BR"]). Defaults to the
+	// HDMI order.
+	ChannelOrder []string      `json:"channelOrder"`
+	Downmix      DownmixConfig `json:"downmix"`
+	// Writable directory where state is remembered across restarts
+	StateDir string        `json:"stateDir"`
+	Alarms   []AlarmConfig `json:"alarms"`
+	// Enables kid mode if set
+	KidMode *KidModeConfig `json:"kidMode"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		MediaDirs: []string{"/media", "./media"},
+		StateDir:  "/var/lib/jukybox",
+	}
+}
+
+func LoadConfig() Config {
+	config := defaultConfig()
+	for _, configFile := range configFiles {
+		f, err := os.Open(configFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Error opening %s: %v", configFile, err)
+			}
+			continue
+		}
+		err = json.NewDecoder(f).Decode(&config)
+		f.Close()
+		if err != nil {
+			log.Printf("Error loading %s: %v", configFile, err)
+			return defaultConfig()
+		}
+		log.Printf("Loaded configuration from %s", configFile)
+		break
+	}
+	return config
+}
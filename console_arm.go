@@ -42,15 +42,35 @@ func runConsole(buttonEvents chan<- Button, termEvents <-chan termbox.Event) {
 						buttonEvents <- AButton
 					case 'b', 'B':
 						buttonEvents <- BButton
+					case 's', 'S':
+						buttonEvents <- SpeedButton
+					case '+', '=':
+						buttonEvents <- VolumeUpButton
+					case '-':
+						buttonEvents <- VolumeDownButton
+					case 'm', 'M':
+						buttonEvents <- MuteButton
+					case 'l', 'L':
+						buttonEvents <- QueueButton
+					case 't', 'T':
+						buttonEvents <- AudioTrackButton
+					case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+						buttonEvents <- DigitButton(int(ev.Ch - '0'))
+					case '#':
+						buttonEvents <- ChapterModeButton
+					case 'f':
+						buttonEvents <- FavouriteButton
+					case 'F':
+						buttonEvents <- FavouritesOnlyButton
 					case 'q', 'Q':
 						buttonEvents <- PowerButton
 					}
 				}
 			}
 		case ev := <-displayEvents:
-			time := fmt.Sprintf("%02d:%02d", int(math.Floor(ev.info.position.Minutes())), int(math.Floor(ev.info.position.Seconds()))%60)
-			line1 := fmt.Sprintf("%s %s %s", ev.info.stateIcon, time, ev.info.title)
-			line2 := fmt.Sprintf("    [%3d] %s", ev.info.chapterIndex, ev.info.chapterTitle)
+			time := fmt.Sprintf("%02d:%02d", int(math.Floor(ev.info.Position.Minutes())), int(math.Floor(ev.info.Position.Seconds()))%60)
+			line1 := fmt.Sprintf("%s %s %s", ev.info.StateIcon, time, ev.info.Title)
+			line2 := fmt.Sprintf("    [%3d] %s", ev.info.ChapterIndex, ev.info.ChapterTitle)
 			termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 			for i, c := range line1 {
 				termbox.SetCell(i, 0, c, termbox.ColorWhite, termbox.ColorDefault)
@@ -84,7 +104,7 @@ func DestroyConsole() {
 var previousInfo *DisplayInfo
 
 func DrawConsole(info DisplayInfo) {
-	if previousInfo == nil || previousInfo.title != info.title || previousInfo.chapterIndex != info.chapterIndex || previousInfo.stateIcon != info.stateIcon {
+	if previousInfo == nil || previousInfo.Title != info.Title || previousInfo.ChapterIndex != info.ChapterIndex || previousInfo.StateIcon != info.StateIcon {
 		previousInfo = &info
 		log.Printf("%#v", info)
 		// displayEvents <- displayEvent{info: info}
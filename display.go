@@ -3,6 +3,7 @@
 package jukybox
 
 import (
+	"fmt"
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 	"image"
@@ -23,6 +24,11 @@ const (
 	SYMBOLS_FONT_SIZE = 16
 )
 
+const (
+	OVERLAY_MARGIN = 8
+	LIST_MAX_ITEMS = 3
+)
+
 const (
 	POSITION_X      = 16
 	POSITION_Y      = DISPLAY_HEIGHT - 3
@@ -30,18 +36,40 @@ const (
 	POSITION_HEIGHT = 4
 )
 
+// DisplayInfo is everything a front-end shows of the player
 type DisplayInfo struct {
-	title    string
-	artist   string
-	position time.Duration
-	duration time.Duration
+	Title    string
+	Artist   string
+	Position time.Duration
+	Duration time.Duration
+
+	ChapterTitle    string
+	ChapterIndex    int
+	ChapterPosition time.Duration
+	ChapterDuration time.Duration
+	// Loop start and end within the chapter, or -1 if not set
+	LoopStart time.Duration
+	LoopEnd   time.Duration
+
+	StateIcon string
+	NextAlarm string
+	Favourite bool
+	// Set when the file failed to play
+	Unplayable bool
 
-	chapterTitle    string
-	chapterIndex    int
-	chapterPosition time.Duration
-	chapterDuration time.Duration
+	// Shown instead of the player if set
+	ListTitle string
+	List      []string
 
-	stateIcon string
+	Overlay *Overlay
+}
+
+// Message that is temporarily shown on top of the screen
+type Overlay struct {
+	Icon string
+	Text string
+	// Fraction shown as a bar, or < 0 for none
+	Level float64
 }
 
 type DisplayDrawer struct {
@@ -88,11 +116,25 @@ func CreateDisplayDrawer() *DisplayDrawer {
 func (d *DisplayDrawer) Draw(display Display, info DisplayInfo) {
 	s := display.Image()
 	draw.Draw(s, s.Bounds(), image.Black, image.ZP, draw.Src)
+	if len(info.ListTitle) > 0 {
+		d.drawList(s, info.ListTitle, info.List)
+	} else {
+		d.drawPlayer(s, info)
+	}
+
+	if info.Overlay != nil {
+		d.drawOverlay(s, info.Overlay)
+	}
+
+	display.Flush()
+}
+
+func (d *DisplayDrawer) drawPlayer(s draw.Image, info DisplayInfo) {
 	d.boldCtx.SetClip(s.Bounds())
 	d.boldCtx.SetDst(s)
 	line1Offset := 2 + d.boldCtx.PointToFixed(BOLD_FONT_SIZE)>>6
 	pt := freetype.Pt(0, int(line1Offset))
-	if _, err := d.boldCtx.DrawString(info.title, pt); err != nil {
+	if _, err := d.boldCtx.DrawString(info.Title, pt); err != nil {
 		log.Fatal(err)
 	}
 
@@ -100,7 +142,7 @@ func (d *DisplayDrawer) Draw(display Display, info DisplayInfo) {
 	d.italicCtx.SetDst(s)
 	line2Offset := line1Offset + (d.boldCtx.PointToFixed(ITALIC_FONT_SIZE) >> 6) + 1
 	pt = freetype.Pt(0, int(line2Offset))
-	if _, err := d.italicCtx.DrawString(info.artist, pt); err != nil {
+	if _, err := d.italicCtx.DrawString(info.Artist, pt); err != nil {
 		log.Fatal(err)
 	}
 
@@ -108,7 +150,23 @@ func (d *DisplayDrawer) Draw(display Display, info DisplayInfo) {
 	d.regularCtx.SetDst(s)
 	line3Offset := line2Offset + (d.boldCtx.PointToFixed(REGULAR_FONT_SIZE) >> 6) + 1
 	pt = freetype.Pt(0, int(line3Offset))
-	if _, err := d.regularCtx.DrawString(info.chapterTitle, pt); err != nil {
+	line3Icon, line3 := "", info.ChapterTitle
+	if info.Unplayable {
+		line3Icon = "\u26A0 "
+	} else if len(info.NextAlarm) > 0 {
+		line3Icon, line3 = "\u23F2 ", info.NextAlarm
+	} else if info.Favourite {
+		line3Icon = "\u2665 "
+	}
+	if len(line3Icon) > 0 {
+		d.symbolsCtx.SetClip(s.Bounds())
+		d.symbolsCtx.SetDst(s)
+		var err error
+		if pt, err = d.symbolsCtx.DrawString(line3Icon, pt); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := d.regularCtx.DrawString(line3, pt); err != nil {
 		log.Fatal(err)
 	}
 
@@ -116,34 +174,110 @@ func (d *DisplayDrawer) Draw(display Display, info DisplayInfo) {
 	d.symbolsCtx.SetDst(s)
 	line4Offset := DISPLAY_HEIGHT - 3
 	pt = freetype.Pt(0, int(line4Offset))
-	if _, err := d.symbolsCtx.DrawString(info.stateIcon, pt); err != nil {
+	if _, err := d.symbolsCtx.DrawString(info.StateIcon, pt); err != nil {
 		log.Fatal(err)
 	}
 
-	if info.duration > 0 {
+	if info.Duration > 0 {
 		draw.Draw(s, image.Rectangle{
 			Min: image.Point{
 				X: POSITION_X,
 				Y: POSITION_Y - (2 * (POSITION_HEIGHT + 2*POSITION_MARGIN)) + POSITION_MARGIN,
 			},
 			Max: image.Point{
-				X: POSITION_X + int((DISPLAY_WIDTH-POSITION_X)*(float64(info.position)/float64(info.duration))),
+				X: POSITION_X + int((DISPLAY_WIDTH-POSITION_X)*(float64(info.Position)/float64(info.Duration))),
 				Y: POSITION_Y - (POSITION_HEIGHT + 2*POSITION_MARGIN) - POSITION_MARGIN,
 			},
 		}, image.White, image.ZP, draw.Src)
 	}
-	if info.chapterDuration > 0 {
+	if info.ChapterDuration > 0 {
 		draw.Draw(s, image.Rectangle{
 			Min: image.Point{
 				X: POSITION_X,
 				Y: POSITION_Y - (POSITION_HEIGHT + 2*POSITION_MARGIN) + POSITION_MARGIN,
 			},
 			Max: image.Point{
-				X: POSITION_X + int((DISPLAY_WIDTH-POSITION_X)*(float64(info.chapterPosition)/float64(info.chapterDuration))),
+				X: POSITION_X + int((DISPLAY_WIDTH-POSITION_X)*(float64(info.ChapterPosition)/float64(info.ChapterDuration))),
 				Y: POSITION_Y - POSITION_MARGIN,
 			},
 		}, image.White, image.ZP, draw.Src)
+		for _, marker := range []time.Duration{info.LoopStart, info.LoopEnd} {
+			if marker >= 0 && marker <= info.ChapterDuration {
+				d.drawLoopMarker(s, marker <= info.ChapterPosition, POSITION_X+int((DISPLAY_WIDTH-POSITION_X)*(float64(marker)/float64(info.ChapterDuration))))
+			}
+		}
 	}
+}
 
-	display.Flush()
+// Draws a marker across the chapter bar, inverted where the bar is filled
+func (d *DisplayDrawer) drawLoopMarker(s draw.Image, filled bool, x int) {
+	color := image.White
+	if filled {
+		color = image.Black
+	}
+	draw.Draw(s, image.Rectangle{
+		Min: image.Point{X: x, Y: POSITION_Y - (POSITION_HEIGHT + 2*POSITION_MARGIN)},
+		Max: image.Point{X: x + 1, Y: POSITION_Y},
+	}, color, image.ZP, draw.Src)
+}
+
+func (d *DisplayDrawer) drawList(s draw.Image, title string, items []string) {
+	d.boldCtx.SetClip(s.Bounds())
+	d.boldCtx.SetDst(s)
+	lineOffset := 2 + d.boldCtx.PointToFixed(BOLD_FONT_SIZE)>>6
+	if _, err := d.boldCtx.DrawString(title, freetype.Pt(0, int(lineOffset))); err != nil {
+		log.Fatal(err)
+	}
+
+	d.regularCtx.SetClip(s.Bounds())
+	d.regularCtx.SetDst(s)
+	for i, item := range items {
+		if i == LIST_MAX_ITEMS {
+			break
+		}
+		if i == LIST_MAX_ITEMS-1 && len(items) > LIST_MAX_ITEMS {
+			item = fmt.Sprintf("\u2026 %d more", len(items)-i)
+		}
+		lineOffset += (d.regularCtx.PointToFixed(REGULAR_FONT_SIZE) >> 6) + 2
+		if _, err := d.regularCtx.DrawString(item, freetype.Pt(0, int(lineOffset))); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func (d *DisplayDrawer) drawOverlay(s draw.Image, overlay *Overlay) {
+	height := int(d.boldCtx.PointToFixed(BOLD_FONT_SIZE)>>6) + 2*OVERLAY_MARGIN
+	box := image.Rect(OVERLAY_MARGIN, (DISPLAY_HEIGHT-height)/2, DISPLAY_WIDTH-OVERLAY_MARGIN, (DISPLAY_HEIGHT+height)/2)
+	draw.Draw(s, box, image.White, image.ZP, draw.Src)
+	inner := box.Inset(1)
+	draw.Draw(s, inner, image.Black, image.ZP, draw.Src)
+	d.boldCtx.SetDst(s)
+	d.symbolsCtx.SetDst(s)
+
+	pt := freetype.Pt(box.Min.X+OVERLAY_MARGIN/2, box.Max.Y-OVERLAY_MARGIN-2)
+	if len(overlay.Icon) > 0 {
+		d.symbolsCtx.SetClip(inner)
+		var err error
+		if pt, err = d.symbolsCtx.DrawString(overlay.Icon+" ", pt); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if overlay.Level >= 0 {
+		barX := int(pt.X >> 6)
+		barWidth := inner.Max.X - OVERLAY_MARGIN/2 - barX
+		level := overlay.Level
+		if level > 1 {
+			level = 1
+		}
+		bar := image.Rect(barX, inner.Min.Y+OVERLAY_MARGIN, barX+barWidth, inner.Max.Y-OVERLAY_MARGIN)
+		draw.Draw(s, bar, image.White, image.ZP, draw.Src)
+		draw.Draw(s, bar.Inset(1), image.Black, image.ZP, draw.Src)
+		bar.Max.X = bar.Min.X + int(float64(barWidth)*level)
+		draw.Draw(s, bar, image.White, image.ZP, draw.Src)
+	} else {
+		d.boldCtx.SetClip(inner)
+		if _, err := d.boldCtx.DrawString(overlay.Text, pt); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
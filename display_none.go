@@ -0,0 +1,8 @@
+// +build !arm,!darwin
+
+package jukybox
+
+// There is no display on other platforms, which only leaves the API
+func CreateDisplay(buttonChannel chan<- Button) Display {
+	return nil
+}
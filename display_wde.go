@@ -65,6 +65,26 @@ func (d *WDEDisplay) run() {
 					d.buttonChannel <- AButton
 				case wde.KeyB:
 					d.buttonChannel <- BButton
+				case wde.KeyS:
+					d.buttonChannel <- SpeedButton
+				case wde.KeyEqual:
+					d.buttonChannel <- VolumeUpButton
+				case wde.KeyMinus:
+					d.buttonChannel <- VolumeDownButton
+				case wde.KeyM:
+					d.buttonChannel <- MuteButton
+				case wde.KeyL:
+					d.buttonChannel <- QueueButton
+				case wde.KeyT:
+					d.buttonChannel <- AudioTrackButton
+				case wde.Key0, wde.Key1, wde.Key2, wde.Key3, wde.Key4, wde.Key5, wde.Key6, wde.Key7, wde.Key8, wde.Key9:
+					d.buttonChannel <- DigitButton(int(event.Key[0] - '0'))
+				case wde.KeyC:
+					d.buttonChannel <- ChapterModeButton
+				case wde.KeyF:
+					d.buttonChannel <- FavouriteButton
+				case wde.KeyG:
+					d.buttonChannel <- FavouritesOnlyButton
 				}
 				// case wde.ResizeEvent:
 				// 	d.window.SetSize(DISPLAY_WIDTH, DISPLAY_HEIGHT)
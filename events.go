@@ -0,0 +1,134 @@
+package jukybox
+
+import (
+	"github.com/remko/jukybox/ffmpeg"
+	"sync"
+	"time"
+)
+
+// Event is published by the App when its state changes. It is one of the
+// *Event types below.
+type Event interface {
+	isEvent()
+}
+
+// The player started or stopped
+type StateEvent struct {
+	State PlayerState
+}
+
+// Another album was selected
+type TrackEvent struct {
+	// File of the album
+	Album    string
+	Title    string
+	Artist   string
+	Duration time.Duration
+}
+
+// Playback moved to another chapter
+type ChapterEvent struct {
+	// Index of the chapter, or -1 if the album has no chapters
+	Index int
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// The playback position changed
+type PositionEvent struct {
+	Position time.Duration
+}
+
+// The media library was (re)loaded
+type LibraryEvent struct {
+	Albums int
+}
+
+// Something went wrong with an album or the audio device
+type ErrorEvent struct {
+	// File of the album, or empty if the error isn't about an album
+	Album string
+	Err   error
+}
+
+// A file or audio stream was opened, and this is how its audio is converted
+// for the audio player
+type OutputEvent struct {
+	Conversion ffmpeg.Conversion
+}
+
+// What the display should show
+type DisplayEvent struct {
+	Info DisplayInfo
+}
+
+func (StateEvent) isEvent()    {}
+func (TrackEvent) isEvent()    {}
+func (ChapterEvent) isEvent()  {}
+func (PositionEvent) isEvent() {}
+func (LibraryEvent) isEvent()  {}
+func (ErrorEvent) isEvent()    {}
+func (OutputEvent) isEvent()   {}
+func (DisplayEvent) isEvent()  {}
+
+// Delivers events to any number of subscribers, without waiting for them.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]bool
+}
+
+type Subscription struct {
+	// Closed when unsubscribing
+	Events <-chan Event
+	events chan Event
+	bus    *EventBus
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[*Subscription]bool{}}
+}
+
+// Subscribe registers a subscriber that can fall behind by bufferSize events.
+// When it falls further behind, the oldest events are dropped, so that it
+// always gets to see the latest state.
+func (b *EventBus) Subscribe(bufferSize int) *Subscription {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	events := make(chan Event, bufferSize)
+	s := &Subscription{Events: events, events: events, bus: b}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[s] = true
+	return s
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if s.bus.subscribers[s] {
+		delete(s.bus.subscribers, s)
+		close(s.events)
+	}
+}
+
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subscribers {
+		for {
+			select {
+			case s.events <- event:
+			default:
+				// Full; make room by dropping the oldest event
+				select {
+				case <-s.events:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package jukybox
+
+import (
+	"sort"
+	"time"
+)
+
+// Favourites are chapters, or whole albums (chapter -1) for albums without
+// chapters, remembered per album file in the saved state.
+
+func (app *App) isFavourite(file string, chapter int) bool {
+	for _, c := range app.savedState.Favourites[file] {
+		if c == chapter {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) hasFavourites(mediaFile *MediaFile) bool {
+	return len(app.savedState.Favourites[mediaFile.file]) > 0
+}
+
+// The chapter that a favourite would be about: the current chapter, or -1 if
+// the album has no chapters
+func (app *App) currentFavouriteChapter() int {
+	_, chapterIndex, ok := findChapter(app.currentFile(), app.currentPosition)
+	if !ok {
+		return -1
+	}
+	return chapterIndex
+}
+
+// Whether the current chapter is a favourite, either by itself or because the
+// whole album is.
+func (app *App) isCurrentFavourite() bool {
+	file := app.currentFile().file
+	return app.isFavourite(file, -1) || app.isFavourite(file, app.currentFavouriteChapter())
+}
+
+func (app *App) toggleFavourite() {
+	file := app.currentFile().file
+	chapter := app.currentFavouriteChapter()
+	favourites := app.savedState.Favourites[file]
+	if app.isFavourite(file, chapter) {
+		for i, c := range favourites {
+			if c == chapter {
+				favourites = append(favourites[:i], favourites[i+1:]...)
+				break
+			}
+		}
+		app.showOverlay("Removed from favourites")
+	} else {
+		favourites = append(favourites, chapter)
+		sort.Ints(favourites)
+		app.setOverlay(&Overlay{Icon: "\u2665", Text: "Favourite", Level: -1})
+	}
+	if len(favourites) == 0 {
+		delete(app.savedState.Favourites, file)
+	} else {
+		app.savedState.Favourites[file] = favourites
+	}
+	if app.savedState.FavouritesOnly && len(app.savedState.Favourites) == 0 {
+		app.savedState.FavouritesOnly = false
+	}
+	app.savedState.save()
+}
+
+func (app *App) toggleFavouritesOnly() {
+	if !app.savedState.FavouritesOnly && len(app.savedState.Favourites) == 0 {
+		app.showOverlay("No favourites")
+		return
+	}
+	app.savedState.FavouritesOnly = !app.savedState.FavouritesOnly
+	app.savedState.save()
+	if app.savedState.FavouritesOnly {
+		app.setOverlay(&Overlay{Icon: "\u2665", Text: "Favourites only", Level: -1})
+		if !app.isCurrentFavourite() {
+			app.advanceFile(1, true)
+		}
+	} else {
+		app.showOverlay("All albums")
+	}
+}
+
+// Whether advancing may stop at the album
+func (app *App) canAdvanceTo(mediaFile *MediaFile) bool {
+	return mediaFile.err == nil && (!app.savedState.FavouritesOnly || app.hasFavourites(mediaFile))
+}
+
+// Start position of the first (or last) favourite chapter in favourites-only
+// mode. ok is false if the whole album can be played.
+func (app *App) favouriteStart(mediaFile *MediaFile, last bool) (time.Duration, bool) {
+	if !app.savedState.FavouritesOnly || app.isFavourite(mediaFile.file, -1) {
+		return 0, false
+	}
+	favourites := app.savedState.Favourites[mediaFile.file]
+	if len(favourites) == 0 {
+		return 0, false
+	}
+	chapter := favourites[0]
+	if last {
+		chapter = favourites[len(favourites)-1]
+	}
+	if chapter < 0 || chapter >= len(mediaFile.chapters) {
+		return 0, false
+	}
+	return mediaFile.chapters[chapter].start, true
+}
+
+// Next (n > 0) or previous favourite chapter from chapterIndex in the current
+// album, or -1 if there is none. restricted is false if all chapters can be
+// played.
+func (app *App) nextFavouriteChapter(chapterIndex int, n int) (next int, restricted bool) {
+	file := app.currentFile().file
+	if !app.savedState.FavouritesOnly || app.isFavourite(file, -1) {
+		return 0, false
+	}
+	favourites := app.savedState.Favourites[file]
+	if n > 0 {
+		for _, c := range favourites {
+			if c > chapterIndex {
+				return c, true
+			}
+		}
+	} else {
+		for i := len(favourites) - 1; i >= 0; i-- {
+			if favourites[i] >= 0 && favourites[i] < chapterIndex {
+				return favourites[i], true
+			}
+		}
+	}
+	return -1, true
+}
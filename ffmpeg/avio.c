@@ -0,0 +1,41 @@
+#include <stdint.h>
+#include <libavformat/avformat.h>
+#include <libavformat/avio.h>
+
+#include "_cgo_export.h"
+
+#define bufferSize 32768
+
+// Declared in avio.go, whose preamble can't hold definitions because of //export
+const int avErrorEOF = AVERROR_EOF;
+const int avErrorIO = AVERROR(EIO);
+const int avSeekSize = AVSEEK_SIZE;
+const int avSeekForce = AVSEEK_FORCE;
+
+static int readPacket(void *opaque, uint8_t *buf, int size) {
+	return goReadPacket((int)(intptr_t)opaque, buf, size);
+}
+
+static int64_t seekPacket(void *opaque, int64_t offset, int whence) {
+	return goSeek((int)(intptr_t)opaque, offset, whence);
+}
+
+AVIOContext *newReaderContext(int handle) {
+	unsigned char *buffer = av_malloc(bufferSize);
+	if (buffer == NULL) {
+		return NULL;
+	}
+	AVIOContext *ioCtx = avio_alloc_context(buffer, bufferSize, 0, (void *)(intptr_t)handle, readPacket, NULL, seekPacket);
+	if (ioCtx == NULL) {
+		av_free(buffer);
+	}
+	return ioCtx;
+}
+
+void freeReaderContext(AVIOContext **ioCtx) {
+	if (*ioCtx != NULL) {
+		// The buffer may have been replaced by FFmpeg, so free the current one
+		av_freep(&(*ioCtx)->buffer);
+	}
+	avio_context_free(ioCtx);
+}
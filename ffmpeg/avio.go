@@ -0,0 +1,140 @@
+package ffmpeg
+
+/*
+#include <stdint.h>
+#include <libavformat/avio.h>
+
+AVIOContext *newReaderContext(int handle);
+void freeReaderContext(AVIOContext **ioCtx);
+
+extern const int avErrorEOF;
+extern const int avErrorIO;
+extern const int avSeekSize;
+extern const int avSeekForce;
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"unsafe"
+)
+
+// Input read by FFmpeg through a Go reader instead of a file
+type readerInput struct {
+	handle int
+	ioCtx  *C.AVIOContext
+}
+
+func openReader(reader io.ReadSeeker) (*readerInput, error) {
+	handle := registerReader(reader)
+	ioCtx := C.newReaderContext(C.int(handle))
+	if ioCtx == nil {
+		unregisterReader(handle)
+		return nil, fmt.Errorf("Unable to allocate IO context")
+	}
+	return &readerInput{handle: handle, ioCtx: ioCtx}, nil
+}
+
+// Must only be called once the format context using it is closed
+func (r *readerInput) close() {
+	C.freeReaderContext(&r.ioCtx)
+	unregisterReader(r.handle)
+}
+
+//export goReadPacket
+func goReadPacket(handle C.int, buf *C.uint8_t, size C.int) C.int {
+	reader := lookupReader(int(handle))
+	if reader == nil {
+		return C.avErrorIO
+	}
+	data := (*[1 << 30]byte)(unsafe.Pointer(buf))[:size:size]
+	n, err := io.ReadAtLeast(reader, data, 1)
+	switch {
+	case n > 0:
+		return C.int(n)
+	case err == io.EOF:
+		return C.avErrorEOF
+	default:
+		log.Printf("Read error: %v", err)
+		return C.avErrorIO
+	}
+}
+
+//export goSeek
+func goSeek(handle C.int, offset C.int64_t, whence C.int) C.int64_t {
+	reader := lookupReader(int(handle))
+	if reader == nil {
+		return C.int64_t(C.avErrorIO)
+	}
+	whence &^= C.avSeekForce
+	if whence == C.avSeekSize {
+		size, err := readerSize(reader)
+		if err != nil {
+			// FFmpeg falls back to reading without knowing the size
+			return -1
+		}
+		return C.int64_t(size)
+	}
+	// The whence values of FFmpeg are those of io.Seeker
+	position, err := reader.Seek(int64(offset), int(whence))
+	if err != nil {
+		log.Printf("Seek error: %v", err)
+		return C.int64_t(C.avErrorIO)
+	}
+	return C.int64_t(position)
+}
+
+// Returns the size of the reader, leaving its position unchanged
+func readerSize(reader io.ReadSeeker) (int64, error) {
+	if sized, ok := reader.(interface{ Size() int64 }); ok {
+		return sized.Size(), nil
+	}
+	position, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := reader.Seek(position, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Reader registry
+//
+// C code can't hold Go pointers, so FFmpeg gets a handle to look the reader up.
+////////////////////////////////////////////////////////////////////////////////
+
+var readersMu sync.Mutex
+var readerIndex int
+var readers = make(map[int]io.ReadSeeker)
+
+func registerReader(reader io.ReadSeeker) int {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+	readerIndex++
+	for readers[readerIndex] != nil {
+		readerIndex++
+	}
+	readers[readerIndex] = reader
+	return readerIndex
+}
+
+func lookupReader(handle int) io.ReadSeeker {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+	return readers[handle]
+}
+
+func unregisterReader(handle int) {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+	delete(readers, handle)
+}
@@ -1,25 +1,48 @@
 package ffmpeg
 
 /*
-#cgo pkg-config: libavformat libavcodec libavutil libswresample
+#cgo pkg-config: libavformat libavcodec libavutil libswresample libavfilter
 
 #include <libavformat/avformat.h>
+#include <libavfilter/avfilter.h>
+#include <libavfilter/buffersink.h>
+#include <libavfilter/buffersrc.h>
 #include <libavutil/error.h>
+#include <libavutil/opt.h>
 #include <libswresample/swresample.h>
 
+#if LIBAVUTIL_VERSION_INT < AV_VERSION_INT(57, 24, 100)
+#error "FFmpeg 5.1 or later is required (AVChannelLayout API)"
+#endif
+
 int averror(int c) {
 	return AVERROR(c);
 }
 
-// FL, FR, LF, C, BL, BR
-int channelMap[] = {0, 1, 3, 2, 4, 5, -1, -1};
+const int64_t noPTS = AV_NOPTS_VALUE;
+
+// Drops the first samples of a frame
+void skipSamples(AVFrame *frame, int samples) {
+	if (av_sample_fmt_is_planar(frame->format)) {
+		for (int i = 0; i < frame->ch_layout.nb_channels && i < AV_NUM_DATA_POINTERS; i++) {
+			frame->data[i] += samples * av_get_bytes_per_sample(frame->format);
+		}
+	} else {
+		frame->data[0] += samples * av_get_bytes_per_sample(frame->format) * frame->ch_layout.nb_channels;
+	}
+	frame->extended_data = frame->data;
+	frame->nb_samples -= samples;
+}
 */
 import "C"
 
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -30,47 +53,382 @@ var EOF = errors.New("EOF")
 var initialize sync.Once
 
 type FFmpeg struct {
-	formatCtx        *C.struct_AVFormatContext
+	formatCtx *C.struct_AVFormatContext
+	// Set when reading from a Go reader instead of a file
+	input            *readerInput
 	streams          []*C.AVStream
 	audioStreamIndex int
-	sampleFormat     C.enum_AVSampleFormat
-	resampler        *C.struct_SwrContext
-	remapper         *C.struct_SwrContext
+	codecCtx         *C.AVCodecContext
+	// Output sample format, and the format it's converted to with FFmpeg.
+	// These only differ for s24, which is packed from S32.
+	outputFormat string
+	sampleFormat C.enum_AVSampleFormat
+	conversion   Conversion
+	resampler    *C.struct_SwrContext
+	remapper     *C.struct_SwrContext
+	// Input channel of each output channel, for the remapper
+	channelMap *C.int
+
+	// Tempo filter (nil when playing at normal speed)
+	tempo         float64
+	filterGraph   *C.AVFilterGraph
+	filterSource  *C.AVFilterContext
+	filterSink    *C.AVFilterContext
+	filteredFrame *C.AVFrame
+	// Position of the next frame out of the filter
+	filterPosition time.Duration
+	filterStarted  bool
 
 	// State for reading
 	readStarted    bool
+	packet         *C.AVPacket
 	frame          *C.AVFrame
 	resampledFrame *C.AVFrame
 	remappedFrame  *C.AVFrame
+	// Converted frames that haven't been read yet
+	pending []*AudioFrame
+	// Whether the decoder and converters have been drained at the end of the
+	// stream
+	drained bool
+	// Position of the next decoded sample
+	position time.Duration
+	// Whether the position is known. It isn't after seeking until a frame
+	// with a timestamp is decoded.
+	positionKnown bool
+	// Target of the last seek, while the frames before it are being skipped
+	seekTarget time.Duration
+	seeking    bool
 }
 
 type AudioFrame struct {
 	Data     []byte
 	Position time.Duration
+	// Duration of the frame in media time
+	Duration time.Duration
 }
 
+var nanosecondBase = C.AVRational{num: 1, den: 1e9}
+
+// Rounds down, so that seeking to the result never lands after the position
 func durationToBase(stream *C.AVStream, position int64) int64 {
-	return (position * int64(stream.time_base.den)) / (1e9 * int64(stream.time_base.num))
+	return int64(C.av_rescale_q_rnd(C.int64_t(position), nanosecondBase, stream.time_base, C.AV_ROUND_DOWN))
 }
 
 func baseToDuration(stream *C.AVStream, position int64) int64 {
-	return (position * 1e9 * int64(stream.time_base.num)) / int64(stream.time_base.den)
+	return int64(C.av_rescale_q(C.int64_t(position), stream.time_base, nanosecondBase))
+}
+
+type Options struct {
+	// Maximum number of output channels. Files with more channels are
+	// downmixed to stereo.
+	MaxChannels int
+	// Index of the audio stream to play, or nil to select one using Languages
+	AudioStream *int
+	// Preferred languages of the audio stream (e.g. "eng"), in order. If none
+	// match, the stream that FFmpeg considers best is used.
+	Languages []string
+	// Sample formats that the output accepts (see SampleFormat). Defaults to
+	// u8, s16 and s32.
+	SampleFormats []string
+	// Sample rates that the output accepts. Empty if any rate works.
+	SampleRates []int
+	// Speakers in the order that the output expects them, as FFmpeg channel
+	// names (e.g. "FL", "LFE"). Defaults to DefaultChannelOrder.
+	ChannelOrder []string
+	Downmix      Downmix
+}
+
+// Channel order of HDMI and ALSA devices
+var DefaultChannelOrder = []string{"FL", "FR", "LFE", "FC", "BL", "BR", "SL", "SR"}
+
+// How audio with more channels than the output is mixed down
+type Downmix struct {
+	// Mix everything into a single channel, for a single speaker
+	Mono bool
+	// Levels (in dB) of the center, surround and LFE channels in the mix. nil
+	// uses FFmpeg's defaults: -3 dB for center and surround, and no LFE.
+	CenterLevel   *float64
+	SurroundLevel *float64
+	LFELevel      *float64
+	// Encodes the surround channels in a stereo mix for a matrix decoder:
+	// "dolby" (Dolby Surround) or "dplii" (Dolby Pro Logic II)
+	Matrix string
+}
+
+// Layouts that audio is mixed down to, from the most channels to the fewest
+var downmixLayouts = []string{"7.1", "5.1", "quad", "stereo", "mono"}
+
+// Determines the output layout for the input layout: the input layout if the
+// output has enough channels, or else the largest downmix layout that fits.
+func outputLayout(layout *C.AVChannelLayout, input *C.AVChannelLayout, maxChannels int, downmix Downmix) error {
+	name := ""
+	switch {
+	case downmix.Mono:
+		name = "mono"
+	case int(input.nb_channels) > maxChannels:
+		for _, name = range downmixLayouts {
+			if downmix.Matrix != "" && name != "stereo" {
+				// Matrix encoding only works for stereo
+				continue
+			}
+			if channels, _ := layoutChannels(name); channels <= maxChannels {
+				break
+			}
+		}
+	default:
+		if err := C.av_channel_layout_copy(layout, input); err < 0 {
+			return avError("copy channel layout", err)
+		}
+		return nil
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if err := C.av_channel_layout_from_string(layout, cName); err < 0 {
+		return avError("channel layout "+name, err)
+	}
+	return nil
+}
+
+func layoutChannels(name string) (int, error) {
+	var layout C.AVChannelLayout
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if err := C.av_channel_layout_from_string(&layout, cName); err < 0 {
+		return 0, avError("channel layout "+name, err)
+	}
+	defer C.av_channel_layout_uninit(&layout)
+	return int(layout.nb_channels), nil
+}
+
+func configureDownmix(resampler *C.struct_SwrContext, downmix Downmix) error {
+	for _, level := range []struct {
+		option string
+		level  *float64
+	}{
+		{"center_mix_level", downmix.CenterLevel},
+		{"surround_mix_level", downmix.SurroundLevel},
+		{"lfe_mix_level", downmix.LFELevel},
+	} {
+		if level.level == nil {
+			continue
+		}
+		gain := math.Pow(10, *level.level/20)
+		if err := setOption(resampler, level.option, fmt.Sprintf("%g", gain)); err != nil {
+			return err
+		}
+	}
+	if downmix.Matrix != "" {
+		if err := setOption(resampler, "matrix_encoding", downmix.Matrix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the input channel of each output channel, so that the channels
+// are in the given order. Channels that aren't in the order come last.
+func channelMapping(layout *C.AVChannelLayout, order []string) ([]C.int, error) {
+	if len(order) == 0 {
+		order = DefaultChannelOrder
+	}
+	numChannels := int(layout.nb_channels)
+	used := make([]bool, numChannels)
+	mapping := make([]C.int, 0, numChannels)
+	for _, name := range order {
+		cName := C.CString(name)
+		channel := C.av_channel_from_string(cName)
+		C.free(unsafe.Pointer(cName))
+		if channel == C.AV_CHAN_NONE {
+			return nil, fmt.Errorf("Unknown channel: %s", name)
+		}
+		if index := int(C.av_channel_layout_index_from_channel(layout, channel)); index >= 0 && !used[index] {
+			mapping = append(mapping, C.int(index))
+			used[index] = true
+		}
+	}
+	for i := range used {
+		if !used[i] {
+			mapping = append(mapping, C.int(i))
+		}
+	}
+	return mapping, nil
+}
+
+func isIdentityMapping(mapping []C.int) bool {
+	for i, index := range mapping {
+		if int(index) != i {
+			return false
+		}
+	}
+	return true
+}
+
+func channelName(channel C.enum_AVChannel) string {
+	buf := make([]C.char, 32)
+	if C.av_channel_name((*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)), channel) < 0 {
+		return "?"
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0])))
+}
+
+// How the decoded audio is converted for the output
+type Conversion struct {
+	InputFormat  string
+	InputRate    int
+	InputLayout  string
+	OutputFormat string
+	OutputRate   int
+	OutputLayout string
+	// Speakers of the output channels, in order
+	Channels      []string
+	RemapChannels bool
+	// Resampler used to change the sample rate ("soxr" or "swr"), or empty if
+	// the rate doesn't change
+	Resampler string
+	// Whether the decoded samples are output as they are
+	BitPerfect bool
+}
+
+func (c Conversion) String() string {
+	result := fmt.Sprintf("%s %s %d Hz -> %s %s %d Hz", c.InputFormat, c.InputLayout, c.InputRate, c.OutputFormat, c.OutputLayout, c.OutputRate)
+	if c.Resampler != "" {
+		result += " (resampled with " + c.Resampler + ")"
+	}
+	if c.RemapChannels {
+		result += fmt.Sprintf(" (channels remapped to %s)", strings.Join(c.Channels, " "))
+	}
+	if c.BitPerfect {
+		result += " (bit-perfect)"
+	}
+	return result
+}
+
+// Picks the output rate: the input rate if it's accepted, or else the lowest
+// higher accepted rate, or else the highest accepted rate.
+func chooseSampleRate(rate int, accepted []int) int {
+	best := 0
+	for _, r := range accepted {
+		if r == rate {
+			return r
+		}
+		if best == 0 || (r > rate && (best < rate || r < best)) || (r < rate && best < rate && r > best) {
+			best = r
+		}
+	}
+	if best == 0 {
+		return rate
+	}
+	return best
+}
+
+// Precision of the output sample formats
+var sampleFormatRanks = map[string]int{"u8": 0, "s16": 1, "s24": 2, "s32": 3, "flt": 4, "fltp": 4}
+
+// Picks the output format for audio that is naturally in the given format:
+// that format if it's accepted, or else the least precise accepted format that
+// holds it without loss, or else the most precise accepted format.
+func chooseSampleFormat(natural string, accepted []string) string {
+	if len(accepted) == 0 {
+		accepted = []string{"u8", "s16", "s32"}
+	}
+	rank := sampleFormatRanks[natural]
+	best, bestRank := "", -1
+	for _, format := range accepted {
+		if format == natural {
+			return format
+		}
+		r, ok := sampleFormatRanks[format]
+		if !ok {
+			continue
+		}
+		holds, bestHolds := r >= rank, bestRank >= rank
+		switch {
+		case best == "":
+		case holds && !bestHolds:
+		case holds && bestHolds && r < bestRank:
+		case !holds && !bestHolds && r > bestRank:
+		default:
+			continue
+		}
+		best, bestRank = format, r
+	}
+	return best
+}
+
+// Stream in a file
+type StreamInfo struct {
+	Index int
+	// "audio", "video", "subtitle", "attachment" or "data"
+	Type     string
+	Language string
+	Title    string
+	Codec    string
+	Profile  string
+	// Audio streams only
+	Channels      int
+	Layout        string
+	SampleRate    int
+	BitsPerSample int
+	// Video streams only, including attached pictures
+	Width  int
+	Height int
+	// -1 when unknown
+	Duration time.Duration
+	BitRate  int64
+	Default  bool
+	Tags     map[string]string
+	// Image data of cover art stored as a stream, nil for other streams
+	AttachedPicture []byte
 }
 
 func Create(file string, maxChannels int) (*FFmpeg, error) {
+	return CreateWithOptions(file, Options{MaxChannels: maxChannels})
+}
+
+func CreateWithOptions(file string, options Options) (*FFmpeg, error) {
+	return create(file, nil, options)
+}
+
+// CreateFromReader decodes audio read from reader rather than from a file, with
+// seeking done through the reader. The name is only a hint for detecting the
+// format, and may be empty. An io.ReaderAt can be wrapped with
+// io.NewSectionReader. The reader must stay open until the FFmpeg is closed.
+func CreateFromReader(name string, reader io.ReadSeeker, options Options) (*FFmpeg, error) {
+	return create(name, reader, options)
+}
+
+// Opens the file, or the reader if not nil, and finds its streams
+func openInput(file string, reader io.ReadSeeker) (*C.struct_AVFormatContext, *readerInput, error) {
 	initialize.Do(func() {
-		C.av_register_all()
 		C.av_log_set_level(C.AV_LOG_WARNING)
 	})
 
 	success := false
 
+	// Set up reading through the reader, if any
+	var formatCtx *C.struct_AVFormatContext
+	var input *readerInput
+	if reader != nil {
+		var err error
+		if input, err = openReader(reader); err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			if !success {
+				input.close()
+			}
+		}()
+		if formatCtx = C.avformat_alloc_context(); formatCtx == nil {
+			return nil, nil, fmt.Errorf("Unable to allocate format context")
+		}
+		formatCtx.pb = input.ioCtx
+	}
+
 	// Open file
 	cFile := C.CString(file)
 	defer C.free(unsafe.Pointer(cFile))
-	var formatCtx *C.struct_AVFormatContext
 	if err := C.avformat_open_input(&formatCtx, cFile, nil, nil); err != 0 {
-		return nil, avError("open input", err)
+		return nil, nil, avError("open input", err)
 	}
 	defer func() {
 		if !success {
@@ -79,125 +437,319 @@ func Create(file string, maxChannels int) (*FFmpeg, error) {
 	}()
 
 	if err := C.avformat_find_stream_info(formatCtx, nil); err != 0 {
-		return nil, avError("find stream info", err)
+		return nil, nil, avError("find stream info", err)
 	}
 
 	// C.av_dump_format(formatCtx, 0, cFile, 0)
 
+	success = true
+	return formatCtx, input, nil
+}
+
+func closeInput(formatCtx **C.struct_AVFormatContext, input *readerInput) {
+	C.avformat_close_input(formatCtx)
+	if input != nil {
+		input.close()
+	}
+}
+
+func create(file string, reader io.ReadSeeker, options Options) (*FFmpeg, error) {
+	maxChannels := options.MaxChannels
+	success := false
+
+	formatCtx, input, err := openInput(file, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !success {
+			closeInput(&formatCtx, input)
+		}
+	}()
+
 	streams := (*[1 << 20]*C.AVStream)(unsafe.Pointer(formatCtx.streams))[:formatCtx.nb_streams:formatCtx.nb_streams]
-	ret := C.av_find_best_stream(formatCtx, C.AVMEDIA_TYPE_AUDIO, -1, -1, nil, 0)
-	if ret < 0 {
-		return nil, avError("find audio stream", ret)
+	audioStreamIndex, err := selectAudioStream(formatCtx, streams, options)
+	if err != nil {
+		return nil, err
 	}
-	audioStreamIndex := int(ret)
 
 	// Open decoder
 	stream := streams[audioStreamIndex]
-	codec := C.avcodec_find_decoder(stream.codec.codec_id)
+	codec := C.avcodec_find_decoder(stream.codecpar.codec_id)
 	if codec == nil {
-		return nil, fmt.Errorf("Unsupported codec: %v", stream.codec.codec_id)
+		return nil, fmt.Errorf("Unsupported codec: %v", stream.codecpar.codec_id)
+	}
+	codecCtx := C.avcodec_alloc_context3(codec)
+	if codecCtx == nil {
+		return nil, fmt.Errorf("Unable to allocate codec context")
 	}
+	defer func() {
+		if !success {
+			C.avcodec_free_context(&codecCtx)
+		}
+	}()
+	if err := C.avcodec_parameters_to_context(codecCtx, stream.codecpar); err < 0 {
+		return nil, avError("copy codec parameters", err)
+	}
+	codecCtx.pkt_timebase = stream.time_base
+	defaultChannelLayout(&codecCtx.ch_layout)
 
-	var options *C.AVDictionary
-	if err := C.avcodec_open2(stream.codec, codec, &options); err != 0 {
+	var codecOptions *C.AVDictionary
+	if err := C.avcodec_open2(codecCtx, codec, &codecOptions); err != 0 {
 		return nil, avError("open codec", err)
 	}
+	defaultChannelLayout(&codecCtx.ch_layout)
 
 	// Determine sample format
-	sampleFormat := stream.codec.sample_fmt
-	switch sampleFormat {
-	case C.AV_SAMPLE_FMT_U8, C.AV_SAMPLE_FMT_S16, C.AV_SAMPLE_FMT_S32 /*, C.AV_SAMPLE_FMT_FLTP*/ :
-		// Don't change format
-	case C.AV_SAMPLE_FMT_U8P:
+	var natural string
+	switch codecCtx.sample_fmt {
+	case C.AV_SAMPLE_FMT_U8, C.AV_SAMPLE_FMT_U8P:
+		natural = "u8"
+	case C.AV_SAMPLE_FMT_S16, C.AV_SAMPLE_FMT_S16P:
+		natural = "s16"
+	case C.AV_SAMPLE_FMT_S32, C.AV_SAMPLE_FMT_S32P:
+		// 24-bit audio is decoded to the top bits of S32
+		natural = "s32"
+		if codecCtx.bits_per_raw_sample > 0 && codecCtx.bits_per_raw_sample <= 24 {
+			natural = "s24"
+		}
+	case C.AV_SAMPLE_FMT_FLTP:
+		natural = "fltp"
+	case C.AV_SAMPLE_FMT_S64, C.AV_SAMPLE_FMT_S64P:
+		natural = "s32"
+	default:
+		natural = "flt"
+	}
+	outputFormat := chooseSampleFormat(natural, options.SampleFormats)
+	var sampleFormat C.enum_AVSampleFormat
+	switch outputFormat {
+	case "u8":
 		sampleFormat = C.AV_SAMPLE_FMT_U8
-	case C.AV_SAMPLE_FMT_S16P:
+	case "s16":
 		sampleFormat = C.AV_SAMPLE_FMT_S16
-	default:
+	case "s24", "s32":
 		sampleFormat = C.AV_SAMPLE_FMT_S32
+	case "flt":
+		sampleFormat = C.AV_SAMPLE_FMT_FLT
+	case "fltp":
+		sampleFormat = C.AV_SAMPLE_FMT_FLTP
+	default:
+		return nil, fmt.Errorf("No supported sample format in %v", options.SampleFormats)
 	}
 
 	// Initialize helper state
+	packet := C.av_packet_alloc()
 	frame := C.av_frame_alloc()
+	resampledFrame := C.av_frame_alloc()
+	remappedFrame := C.av_frame_alloc()
+	var resampler *C.struct_SwrContext
+	var remapper *C.struct_SwrContext
+	var channelMap *C.int
+	defer func() {
+		if !success {
+			C.free(unsafe.Pointer(channelMap))
+			C.swr_free(&remapper)
+			C.swr_free(&resampler)
+			C.av_frame_free(&remappedFrame)
+			C.av_frame_free(&resampledFrame)
+			C.av_frame_free(&frame)
+			C.av_packet_free(&packet)
+		}
+	}()
 
 	// Determine the output settings
-	resampledFrame := C.av_frame_alloc()
-	resampledFrame.channel_layout = stream.codec.channel_layout
-	if int(C.av_get_channel_layout_nb_channels(resampledFrame.channel_layout)) > maxChannels {
-		// Only our test setup doesn't have > 2 channels. Forcing stereo.
-		resampledFrame.channel_layout = C.AV_CH_LAYOUT_STEREO
+	if err := outputLayout(&resampledFrame.ch_layout, &codecCtx.ch_layout, maxChannels, options.Downmix); err != nil {
+		return nil, err
 	}
-	resampledFrame.sample_rate = stream.codec.sample_rate
+	resampledFrame.sample_rate = C.int(chooseSampleRate(int(codecCtx.sample_rate), options.SampleRates))
 	resampledFrame.format = C.int(sampleFormat)
-	var resampler *C.struct_SwrContext
-	if sampleFormat != stream.codec.sample_fmt || resampledFrame.channel_layout != stream.codec.channel_layout {
-		resampler = C.swr_alloc_set_opts(nil,
-			C.int64_t(resampledFrame.channel_layout),
+	resamplerName := ""
+	if sampleFormat != codecCtx.sample_fmt || resampledFrame.sample_rate != codecCtx.sample_rate || C.av_channel_layout_compare(&resampledFrame.ch_layout, &codecCtx.ch_layout) != 0 {
+		C.swr_alloc_set_opts2(&resampler,
+			&resampledFrame.ch_layout,
 			int32(resampledFrame.format),
 			resampledFrame.sample_rate,
-			C.int64_t(stream.codec.channel_layout),
-			stream.codec.sample_fmt,
-			stream.codec.sample_rate,
+			&codecCtx.ch_layout,
+			codecCtx.sample_fmt,
+			codecCtx.sample_rate,
 			0, nil)
+		if resampler == nil {
+			return nil, fmt.Errorf("Unable to allocate resampler")
+		}
+		if err := configureDownmix(resampler, options.Downmix); err != nil {
+			return nil, err
+		}
+		if resampledFrame.sample_rate != codecCtx.sample_rate {
+			name, err := configureResampler(resampler)
+			if err != nil {
+				return nil, err
+			}
+			resamplerName = name
+		}
 	}
 
 	// Determine remapping
-	remappedFrame := C.av_frame_alloc()
-	remappedFrame.channel_layout = resampledFrame.channel_layout
+	mapping, err := channelMapping(&resampledFrame.ch_layout, options.ChannelOrder)
+	if err != nil {
+		return nil, err
+	}
+	C.av_channel_layout_copy(&remappedFrame.ch_layout, &resampledFrame.ch_layout)
 	remappedFrame.sample_rate = resampledFrame.sample_rate
 	remappedFrame.format = resampledFrame.format
-	remapper := C.swr_alloc_set_opts(nil,
-		C.int64_t(resampledFrame.channel_layout),
-		int32(resampledFrame.format),
-		resampledFrame.sample_rate,
-		C.int64_t(resampledFrame.channel_layout),
-		int32(resampledFrame.format),
-		resampledFrame.sample_rate,
-		0, nil)
-
-	// Debug
-	log.Printf("Input: %v %v %v %v",
-		C.GoString(C.avcodec_get_name(stream.codec.codec_id)),
-		C.GoString(C.av_get_sample_fmt_name(int32(stream.codec.sample_fmt))),
-		C.av_get_channel_layout_nb_channels(stream.codec.channel_layout),
-		stream.codec.sample_rate)
-	log.Printf("Output: pcm %v %v %v (remap: %v, resample: %v)",
-		C.GoString(C.av_get_sample_fmt_name(int32(resampledFrame.format))),
-		C.av_get_channel_layout_nb_channels(resampledFrame.channel_layout),
-		resampledFrame.sample_rate,
-		remapper != nil,
-		resampler != nil)
+	if !isIdentityMapping(mapping) {
+		// The resampler keeps using the mapping, so it lives in C memory
+		channelMap = (*C.int)(C.malloc(C.size_t(len(mapping)) * C.size_t(unsafe.Sizeof(C.int(0)))))
+		copy((*[1 << 10]C.int)(unsafe.Pointer(channelMap))[:len(mapping):len(mapping)], mapping)
+		C.swr_alloc_set_opts2(&remapper,
+			&resampledFrame.ch_layout,
+			int32(resampledFrame.format),
+			resampledFrame.sample_rate,
+			&resampledFrame.ch_layout,
+			int32(resampledFrame.format),
+			resampledFrame.sample_rate,
+			0, nil)
+		if remapper == nil {
+			return nil, fmt.Errorf("Unable to allocate remapper")
+		}
+	}
+	channels := make([]string, len(mapping))
+	for i, index := range mapping {
+		channels[i] = channelName(C.av_channel_layout_channel_from_index(&resampledFrame.ch_layout, C.uint(index)))
+	}
+
+	conversion := Conversion{
+		InputFormat:   C.GoString(C.av_get_sample_fmt_name(int32(codecCtx.sample_fmt))),
+		InputRate:     int(codecCtx.sample_rate),
+		InputLayout:   channelLayoutName(&codecCtx.ch_layout),
+		OutputFormat:  outputFormat,
+		OutputRate:    int(resampledFrame.sample_rate),
+		OutputLayout:  channelLayoutName(&resampledFrame.ch_layout),
+		Channels:      channels,
+		RemapChannels: remapper != nil,
+		Resampler:     resamplerName,
+		BitPerfect:    remapper == nil && resampler == nil,
+	}
+	log.Printf("Input: %v", C.GoString(C.avcodec_get_name(codecCtx.codec_id)))
+	log.Printf("Conversion: %v", conversion)
 
 	success = true
 	return &FFmpeg{
 		formatCtx:        formatCtx,
+		input:            input,
 		streams:          streams,
 		audioStreamIndex: audioStreamIndex,
+		codecCtx:         codecCtx,
+		outputFormat:     outputFormat,
 		sampleFormat:     sampleFormat,
+		conversion:       conversion,
 		resampler:        resampler,
 		remapper:         remapper,
+		channelMap:       channelMap,
+		tempo:            1,
 
+		packet:         packet,
 		frame:          frame,
 		resampledFrame: resampledFrame,
 		remappedFrame:  remappedFrame,
 	}, nil
 }
 
+// Sets up high-quality sample rate conversion, with the SoX resampler if
+// FFmpeg has it. Returns the name of the resampler.
+func configureResampler(resampler *C.struct_SwrContext) (string, error) {
+	if setOption(resampler, "resampler", "soxr") == nil && setOption(resampler, "precision", "28") == nil && C.swr_init(resampler) >= 0 {
+		return "soxr", nil
+	}
+	for _, option := range [][2]string{{"resampler", "swr"}, {"filter_size", "64"}, {"phase_shift", "12"}, {"cutoff", "0.97"}} {
+		if err := setOption(resampler, option[0], option[1]); err != nil {
+			return "", err
+		}
+	}
+	if err := C.swr_init(resampler); err < 0 {
+		return "", avError("initialize resampler", err)
+	}
+	return "swr", nil
+}
+
+func setOption(resampler *C.struct_SwrContext, name string, value string) error {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	if err := C.av_opt_set(unsafe.Pointer(resampler), cName, cValue, 0); err < 0 {
+		return avError("set resampler option "+name, err)
+	}
+	return nil
+}
+
+func selectAudioStream(formatCtx *C.struct_AVFormatContext, streams []*C.AVStream, options Options) (int, error) {
+	if options.AudioStream != nil {
+		index := *options.AudioStream
+		if index >= 0 && index < len(streams) && streams[index].codecpar.codec_type == C.AVMEDIA_TYPE_AUDIO {
+			return index, nil
+		}
+		log.Printf("Audio stream %d not found", index)
+	}
+	for _, language := range options.Languages {
+		for i, stream := range streams {
+			if stream.codecpar.codec_type == C.AVMEDIA_TYPE_AUDIO && streamTag(stream, "language") == language {
+				return i, nil
+			}
+		}
+	}
+	ret := C.av_find_best_stream(formatCtx, C.AVMEDIA_TYPE_AUDIO, -1, -1, nil, 0)
+	if ret < 0 {
+		return -1, avError("find audio stream", ret)
+	}
+	return int(ret), nil
+}
+
+func streamTag(stream *C.AVStream, key string) string {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	entry := C.av_dict_get(stream.metadata, cKey, nil, 0)
+	if entry == nil {
+		return ""
+	}
+	return C.GoString(entry.value)
+}
+
+// AudioStreams lists the audio streams in the file
+func (f *FFmpeg) AudioStreams() []StreamInfo {
+	result := []StreamInfo{}
+	for i, stream := range f.streams {
+		if stream.codecpar.codec_type != C.AVMEDIA_TYPE_AUDIO {
+			continue
+		}
+		result = append(result, streamInfo(i, stream))
+	}
+	return result
+}
+
+// AudioStream returns the index of the stream that is played
+func (f *FFmpeg) AudioStream() int {
+	return f.audioStreamIndex
+}
+
 func (f *FFmpeg) Close() {
+	f.freeTempoFilter()
 	if f.remapper != nil {
 		C.swr_free(&f.remapper)
 	}
+	C.free(unsafe.Pointer(f.channelMap))
 	C.av_frame_free(&f.remappedFrame)
 	if f.resampler != nil {
 		C.swr_free(&f.resampler)
 	}
 	C.av_frame_free(&f.resampledFrame)
 	C.av_frame_free(&f.frame)
-	C.avcodec_close(f.audioStream().codec)
-	C.avformat_close_input(&f.formatCtx)
+	C.av_packet_free(&f.packet)
+	C.avcodec_free_context(&f.codecCtx)
+	closeInput(&f.formatCtx, f.input)
 }
 
 func (f *FFmpeg) Codec() (string, string) {
-	codecCtx := f.audioStream().codec
+	codecCtx := f.codecCtx
 	codec := C.GoString(C.avcodec_get_name(codecCtx.codec_id))
 	profile := C.avcodec_profile_name(codecCtx.codec_id, codecCtx.profile)
 	if profile != nil {
@@ -211,97 +763,344 @@ func (f *FFmpeg) SampleRate() int {
 	return int(f.resampledFrame.sample_rate)
 }
 
+// Conversion describes how the decoded audio is converted for the output.
+func (f *FFmpeg) Conversion() Conversion {
+	return f.conversion
+}
+
+// SampleFormat returns the format of the decoded samples: one of the formats
+// in Options.SampleFormats.
+func (f *FFmpeg) SampleFormat() string {
+	return f.outputFormat
+}
+
 func (f *FFmpeg) BytesPerSample() int {
+	if f.outputFormat == "s24" {
+		return 3
+	}
 	return int(C.av_get_bytes_per_sample(int32(f.sampleFormat)))
 }
 
 func (f *FFmpeg) NumChannels() int {
-	return int(C.av_get_channel_layout_nb_channels(f.resampledFrame.channel_layout))
+	return int(f.resampledFrame.ch_layout.nb_channels)
 }
 
 func (f *FFmpeg) IsFloatPlanar() bool {
 	return f.resampledFrame.format == C.AV_SAMPLE_FMT_FLTP
 }
 
+// SetTempo changes the playback speed, without changing the pitch.
+// Positions of decoded frames are still reported in media time.
+func (f *FFmpeg) SetTempo(tempo float64) error {
+	if tempo < 0.5 || tempo > 2 {
+		return fmt.Errorf("Unsupported tempo: %v", tempo)
+	}
+	f.freeTempoFilter()
+	f.tempo = tempo
+	if tempo == 1 {
+		return nil
+	}
+	return f.createTempoFilter()
+}
+
+func (f *FFmpeg) Tempo() float64 {
+	return f.tempo
+}
+
+func (f *FFmpeg) createTempoFilter() error {
+	success := false
+	graph := C.avfilter_graph_alloc()
+	if graph == nil {
+		return fmt.Errorf("Unable to allocate filter graph")
+	}
+	defer func() {
+		if !success {
+			C.avfilter_graph_free(&graph)
+		}
+	}()
+
+	sampleFormat := C.GoString(C.av_get_sample_fmt_name(int32(f.resampledFrame.format)))
+	createFilter := func(name string, args string) (*C.AVFilterContext, error) {
+		cName := C.CString(name)
+		defer C.free(unsafe.Pointer(cName))
+		cArgs := C.CString(args)
+		defer C.free(unsafe.Pointer(cArgs))
+		var ctx *C.AVFilterContext
+		if err := C.avfilter_graph_create_filter(&ctx, C.avfilter_get_by_name(cName), cName, cArgs, nil, graph); err < 0 {
+			return nil, avError("create filter "+name, err)
+		}
+		return ctx, nil
+	}
+	channelLayout := channelLayoutName(&f.resampledFrame.ch_layout)
+	source, err := createFilter("abuffer", fmt.Sprintf("time_base=1/%d:sample_rate=%d:sample_fmt=%s:channel_layout=%s",
+		f.resampledFrame.sample_rate, f.resampledFrame.sample_rate, sampleFormat, channelLayout))
+	if err != nil {
+		return err
+	}
+	tempo, err := createFilter("atempo", fmt.Sprintf("tempo=%v", f.tempo))
+	if err != nil {
+		return err
+	}
+	format, err := createFilter("aformat", fmt.Sprintf("sample_fmts=%s:sample_rates=%d:channel_layouts=%s",
+		sampleFormat, f.resampledFrame.sample_rate, channelLayout))
+	if err != nil {
+		return err
+	}
+	sink, err := createFilter("abuffersink", "")
+	if err != nil {
+		return err
+	}
+	if err := C.avfilter_link(source, 0, tempo, 0); err != 0 {
+		return avError("link filter", err)
+	}
+	if err := C.avfilter_link(tempo, 0, format, 0); err != 0 {
+		return avError("link filter", err)
+	}
+	if err := C.avfilter_link(format, 0, sink, 0); err != 0 {
+		return avError("link filter", err)
+	}
+	if err := C.avfilter_graph_config(graph, nil); err < 0 {
+		return avError("configure filter graph", err)
+	}
+
+	success = true
+	f.filterGraph = graph
+	f.filterSource = source
+	f.filterSink = sink
+	f.filteredFrame = C.av_frame_alloc()
+	f.filterStarted = false
+	return nil
+}
+
+func (f *FFmpeg) freeTempoFilter() {
+	if f.filterGraph != nil {
+		C.avfilter_graph_free(&f.filterGraph)
+		C.av_frame_free(&f.filteredFrame)
+		f.filterSource = nil
+		f.filterSink = nil
+	}
+}
+
 func (f *FFmpeg) audioStream() *C.struct_AVStream {
 	return f.streams[f.audioStreamIndex]
 }
 
 func (f *FFmpeg) ReadAudioFrame() (*AudioFrame, error) {
-	stream := f.audioStream()
-	resampledFrame := f.resampledFrame
-	remappedFrame := f.remappedFrame
-	frame := f.frame
-	resampler := f.resampler
-	remapper := f.remapper
-
 	// Initialize reader
 	if !f.readStarted {
-		if resampler != nil {
-			if err := C.swr_init(resampler); err != 0 {
+		if f.resampler != nil {
+			if err := C.swr_init(f.resampler); err != 0 {
 				return nil, avError("initialize resampler", err)
 			}
 		}
-		if remapper != nil {
-			if err := C.swr_set_channel_mapping(remapper, &C.channelMap[0]); err != 0 {
+		if f.remapper != nil {
+			if err := C.swr_set_channel_mapping(f.remapper, f.channelMap); err != 0 {
 				return nil, avError("set channel mapping", err)
 			}
-			if err := C.swr_init(remapper); err != 0 {
+			if err := C.swr_init(f.remapper); err != 0 {
 				return nil, avError("initialize remapper", err)
 			}
 		}
 		f.readStarted = true
 	}
 
-	// Read a packet until we have a frame
-	for {
-		var packet C.AVPacket
-		if err := f.readPacket(&packet); err != nil {
-			if err == EOF {
-				return nil, nil
-			} else {
-				return nil, err
-			}
+	for len(f.pending) == 0 {
+		if f.drained {
+			return nil, nil
 		}
-		defer C.av_packet_unref(&packet)
+		if err := f.decode(); err != nil {
+			return nil, err
+		}
+	}
+	audioFrame := f.pending[0]
+	f.pending = f.pending[1:]
+	return audioFrame, nil
+}
 
-		// Decode a frame
-		if err := C.avcodec_send_packet(stream.codec, &packet); err != 0 {
-			return nil, avError("send packet", err)
+// Takes one decoding step: receives a decoded frame, feeds the decoder with
+// the next packet, or drains the decoder and converters at the end of the
+// stream. Converted frames are added to f.pending.
+func (f *FFmpeg) decode() error {
+	err := C.avcodec_receive_frame(f.codecCtx, f.frame)
+	switch {
+	case err == 0:
+		defer C.av_frame_unref(f.frame)
+		defaultChannelLayout(&f.frame.ch_layout)
+		if pts := f.frame.best_effort_timestamp; pts != C.noPTS {
+			f.position = time.Duration(baseToDuration(f.audioStream(), int64(pts)))
+		} else if !f.positionKnown {
+			// Assume that the seek landed on its target
+			f.position = f.seekTarget
 		}
+		f.positionKnown = true
+		return f.convert(f.frame)
 
-		err := C.avcodec_receive_frame(stream.codec, frame)
-		if err == C.averror(C.EAGAIN) {
-			continue
-		} else if err != 0 {
-			return nil, avError("receive frame", err)
-		} else {
-			break
+	case err == C.averror(C.EAGAIN):
+		// The decoder needs another packet
+		if err := f.readPacket(f.packet); err == EOF {
+			if err := C.avcodec_send_packet(f.codecCtx, nil); err != 0 {
+				return avError("flush decoder", err)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer C.av_packet_unref(f.packet)
+		if err := C.avcodec_send_packet(f.codecCtx, f.packet); err != 0 {
+			return avError("send packet", err)
+		}
+		return nil
+
+	case err == C.AVERROR_EOF:
+		// All frames are out of the decoder
+		if err := f.convert(nil); err != nil {
+			return err
 		}
+		f.drained = true
+		return nil
+
+	default:
+		return avError("receive frame", err)
+	}
+}
+
+// Drops the samples of a converted frame at position that come before the
+// seek target. Returns the new position of the frame, and false if the whole
+// frame is dropped.
+func (f *FFmpeg) skipToSeekTarget(frame *C.AVFrame, position time.Duration) (time.Duration, bool) {
+	if position > f.seekTarget {
+		f.seeking = false
+		return position, true
+	}
+	// Rounded to the nearest sample, as positions are rounded to nanoseconds
+	skip := (int64(f.seekTarget-position)*int64(frame.sample_rate) + int64(time.Second)/2) / int64(time.Second)
+	if skip >= int64(frame.nb_samples) {
+		return position, false
+	}
+	f.seeking = false
+	if skip > 0 {
+		C.skipSamples(frame, C.int(skip))
+	}
+	return f.seekTarget, true
+}
+
+// Converts a decoded frame, or flushes the converters if frame is nil
+func (f *FFmpeg) convert(frame *C.AVFrame) error {
+	position := f.position
+	if frame != nil {
+		f.position += time.Duration(float64(frame.nb_samples) * float64(time.Second) / float64(frame.sample_rate))
 	}
 
-	// Convert frame
 	outFrame := frame
-	if resampler != nil {
-		if err := C.swr_convert_frame(resampler, resampledFrame, frame); err != 0 {
-			return nil, avError("resample frame", err)
+	if f.resampler != nil {
+		resetFrame(f.resampledFrame)
+		if err := C.swr_convert_frame(f.resampler, f.resampledFrame, outFrame); err != 0 {
+			return avError("resample frame", err)
 		}
-		outFrame = resampledFrame
+		outFrame = f.resampledFrame
 	}
-	if remapper != nil {
-		if err := C.swr_convert_frame(remapper, remappedFrame, outFrame); err != 0 {
-			return nil, avError("remap frame", err)
+	if f.remapper != nil {
+		resetFrame(f.remappedFrame)
+		if err := C.swr_convert_frame(f.remapper, f.remappedFrame, outFrame); err != 0 {
+			return avError("remap frame", err)
 		}
-		outFrame = remappedFrame
+		outFrame = f.remappedFrame
+	}
+	hasSamples := outFrame != nil && outFrame.nb_samples > 0
+	if hasSamples && f.conversion.Resampler != "" {
+		// The resampler holds back some samples, so the output ends before the
+		// input does
+		delay := time.Duration(C.swr_get_delay(f.resampler, C.int64_t(time.Second)))
+		position = f.position - delay - time.Duration(int64(outFrame.nb_samples)*int64(time.Second)/int64(outFrame.sample_rate))
 	}
+	if hasSamples && f.seeking {
+		// Skipping happens after conversion, where each channel (or all of
+		// them) has its samples in a single buffer
+		position, hasSamples = f.skipToSeekTarget(outFrame, position)
+	}
+
+	if f.filterGraph == nil {
+		if hasSamples {
+			f.pending = append(f.pending, f.toAudioFrame(outFrame, position))
+		}
+		return nil
+	}
+
+	// Change tempo
+	if hasSamples {
+		if !f.filterStarted {
+			f.filterPosition = position
+			f.filterStarted = true
+		}
+		if err := C.av_buffersrc_write_frame(f.filterSource, outFrame); err < 0 {
+			return avError("filter frame", err)
+		}
+	}
+	if frame == nil {
+		if err := C.av_buffersrc_write_frame(f.filterSource, nil); err < 0 {
+			return avError("flush filter", err)
+		}
+	}
+	for {
+		err := C.av_buffersink_get_frame(f.filterSink, f.filteredFrame)
+		if err == C.averror(C.EAGAIN) || err == C.AVERROR_EOF {
+			return nil
+		} else if err < 0 {
+			return avError("get filtered frame", err)
+		}
+		audioFrame := f.toAudioFrame(f.filteredFrame, f.filterPosition)
+		C.av_frame_unref(f.filteredFrame)
+		f.filterPosition += audioFrame.Duration
+		f.pending = append(f.pending, audioFrame)
+	}
+}
+
+// Packs S32 samples into 24 bits in place, keeping the most significant bytes
+func packS24(data []byte) []byte {
+	j := 0
+	for i := 0; i+3 < len(data); i += 4 {
+		data[j], data[j+1], data[j+2] = data[i+1], data[i+2], data[i+3]
+		j += 3
+	}
+	return data[:j]
+}
+
+// Drops the samples of an output frame, keeping its format, so that the
+// resampler allocates a buffer of the right size for the next conversion
+func resetFrame(frame *C.AVFrame) {
+	format := frame.format
+	sampleRate := frame.sample_rate
+	var layout C.AVChannelLayout
+	C.av_channel_layout_copy(&layout, &frame.ch_layout)
+	C.av_frame_unref(frame)
+	frame.format = format
+	frame.sample_rate = sampleRate
+	frame.ch_layout = layout
+}
 
-	numChannels := C.av_get_channel_layout_nb_channels(outFrame.channel_layout)
-	bytesPerSample := C.av_get_bytes_per_sample(int32(outFrame.format))
-	lineSize := outFrame.nb_samples * bytesPerSample * numChannels
+func (f *FFmpeg) toAudioFrame(outFrame *C.AVFrame, position time.Duration) *AudioFrame {
+	numChannels := int(outFrame.ch_layout.nb_channels)
+	bytesPerSample := int(C.av_get_bytes_per_sample(int32(outFrame.format)))
+	var data []byte
+	if C.av_sample_fmt_is_planar(int32(outFrame.format)) != 0 {
+		// The planes of all channels, one after the other
+		planeSize := int(outFrame.nb_samples) * bytesPerSample
+		planes := (*[1 << 10]*C.uint8_t)(unsafe.Pointer(outFrame.extended_data))[:numChannels:numChannels]
+		data = make([]byte, 0, planeSize*numChannels)
+		for _, plane := range planes {
+			data = append(data, C.GoBytes(unsafe.Pointer(plane), C.int(planeSize))...)
+		}
+	} else {
+		data = C.GoBytes(unsafe.Pointer(*outFrame.extended_data), C.int(int(outFrame.nb_samples)*bytesPerSample*numChannels))
+	}
+	if f.outputFormat == "s24" {
+		data = packS24(data)
+	}
 	return &AudioFrame{
-		Data:     C.GoBytes(unsafe.Pointer(*outFrame.extended_data), lineSize),
-		Position: time.Duration(baseToDuration(stream, int64(frame.pts))),
-	}, nil
+		Data:     data,
+		Position: position,
+		Duration: time.Duration(float64(outFrame.nb_samples) * f.tempo * float64(time.Second) / float64(outFrame.sample_rate)),
+	}
 }
 
 func (f *FFmpeg) readPacket(packet *C.AVPacket) error {
@@ -323,30 +1122,95 @@ func (f *FFmpeg) readPacket(packet *C.AVPacket) error {
 	return nil
 }
 
+// Seek jumps to the given position. The first frame read afterwards starts
+// exactly there: seeking lands on a packet at or before the position, and the
+// samples before it are decoded and dropped.
 func (f *FFmpeg) Seek(position time.Duration) error {
 	log.Printf("Seeking to %v", position)
-	if err := C.av_seek_frame(f.formatCtx, -1, C.int64_t(position/1000), 0); err != 0 {
+	timestamp := durationToBase(f.audioStream(), int64(position))
+	if err := C.av_seek_frame(f.formatCtx, C.int(f.audioStreamIndex), C.int64_t(timestamp), C.AVSEEK_FLAG_BACKWARD); err < 0 {
 		return avError("seek", err)
 	}
+
+	// Drop the audio buffered in the decoder and converters
+	C.avcodec_flush_buffers(f.codecCtx)
+	f.pending = nil
+	f.drained = false
+	f.positionKnown = false
+	f.seekTarget = position
+	f.seeking = true
+	if f.readStarted {
+		for _, converter := range []*C.struct_SwrContext{f.resampler, f.remapper} {
+			if converter != nil {
+				if err := C.swr_init(converter); err != 0 {
+					return avError("reset converter", err)
+				}
+			}
+		}
+	}
+	if f.filterGraph != nil {
+		// Drop the audio buffered in the tempo filter
+		f.freeTempoFilter()
+		return f.createTempoFilter()
+	}
 	return nil
 }
 
+// ReadAudioPacket returns the next undecoded packet. Packets can't be cut, so
+// after seeking, the first packet is the one containing the target.
 func (f *FFmpeg) ReadAudioPacket() (*AudioFrame, error) {
-	var packet C.AVPacket
-	if err := f.readPacket(&packet); err != nil {
-		if err == EOF {
-			return nil, nil
-		} else {
-			return nil, err
+	packet := f.packet
+	stream := f.audioStream()
+	for {
+		if err := f.readPacket(packet); err != nil {
+			if err == EOF {
+				return nil, nil
+			} else {
+				return nil, err
+			}
+		}
+
+		if packet.pts != C.noPTS {
+			f.position = time.Duration(baseToDuration(stream, int64(packet.pts)))
+		} else if !f.positionKnown {
+			f.position = f.seekTarget
+		}
+		f.positionKnown = true
+		duration := time.Duration(baseToDuration(stream, int64(packet.duration)))
+		position := f.position
+		f.position += duration
+
+		if f.seeking && position+duration <= f.seekTarget && duration > 0 {
+			// Entirely before the seek target
+			C.av_packet_unref(packet)
+			continue
 		}
+		f.seeking = false
+		audioFrame := &AudioFrame{
+			Data:     C.GoBytes(unsafe.Pointer(packet.data), packet.size),
+			Position: position,
+			Duration: duration,
+		}
+		C.av_packet_unref(packet)
+		return audioFrame, nil
 	}
-	defer C.av_packet_unref(&packet)
+}
 
-	stream := f.audioStream()
-	return &AudioFrame{
-		Data:     C.GoBytes(unsafe.Pointer(packet.data), packet.size),
-		Position: time.Duration(baseToDuration(stream, int64(packet.pts))),
-	}, nil
+// When only the number of channels is known, assumes the usual layout for it
+func defaultChannelLayout(layout *C.AVChannelLayout) {
+	if layout.order == C.AV_CHANNEL_ORDER_UNSPEC && layout.nb_channels > 0 {
+		numChannels := layout.nb_channels
+		C.av_channel_layout_uninit(layout)
+		C.av_channel_layout_default(layout, numChannels)
+	}
+}
+
+func channelLayoutName(layout *C.AVChannelLayout) string {
+	buf := make([]C.char, 64)
+	if C.av_channel_layout_describe(layout, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf))) < 0 {
+		return fmt.Sprintf("%dc", layout.nb_channels)
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0])))
 }
 
 func avError(message string, err C.int) error {
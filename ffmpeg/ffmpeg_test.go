@@ -0,0 +1,787 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Reads all frames, checking that positions follow each other. Returns the
+// total number of bytes and the total duration.
+func readAll(t *testing.T, f *FFmpeg) (int, time.Duration) {
+	t.Helper()
+	size := 0
+	var duration time.Duration
+	var expectedPosition time.Duration
+	for i := 0; ; i++ {
+		frame, err := f.ReadAudioFrame()
+		if err != nil {
+			t.Fatalf("ReadAudioFrame: %v", err)
+		}
+		if frame == nil {
+			break
+		}
+		if len(frame.Data) == 0 {
+			t.Fatalf("frame %d is empty", i)
+		}
+		if i > 0 && absDuration(frame.Position-expectedPosition) > time.Millisecond {
+			t.Errorf("frame %d: position %v, expected %v", i, frame.Position, expectedPosition)
+		}
+		expectedPosition = frame.Position + frame.Duration
+		size += len(frame.Data)
+		duration += frame.Duration
+	}
+	return size, duration
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func open(t *testing.T, file string, maxChannels int) *FFmpeg {
+	t.Helper()
+	f, err := Create(file, maxChannels)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestPCMFormats(t *testing.T) {
+	tests := []struct {
+		name               string
+		channels           int
+		format             sampleFormat
+		maxChannels        int
+		wantCodec          string
+		wantChannels       int
+		wantBytesPerSample int
+	}{
+		{"mono s16", 1, formatS16, 2, "pcm_s16le", 1, 2},
+		{"stereo s16", 2, formatS16, 2, "pcm_s16le", 2, 2},
+		{"stereo s32", 2, formatS32, 2, "pcm_s32le", 2, 4},
+		// Float is converted to S32
+		{"stereo f32", 2, formatF32, 2, "pcm_f32le", 2, 4},
+		{"5.1 s16", 6, formatS16, 8, "pcm_s16le", 6, 2},
+		{"5.1 s32", 6, formatS32, 8, "pcm_s32le", 6, 4},
+		// Downmixed when the output has fewer channels
+		{"5.1 s16 to stereo", 6, formatS16, 2, "pcm_s16le", 2, 2},
+		{"5.1 f32 to stereo", 6, formatF32, 2, "pcm_f32le", 2, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			const sampleRate = 48000
+			file := createWAV(t, fixture{channels: test.channels, format: test.format, sampleRate: sampleRate, samples: sampleRate})
+			f := open(t, file, test.maxChannels)
+
+			if codec, profile := f.Codec(); codec != test.wantCodec || profile != "" {
+				t.Errorf("Codec() = %q, %q, expected %q", codec, profile, test.wantCodec)
+			}
+			if f.NumChannels() != test.wantChannels {
+				t.Errorf("NumChannels() = %d, expected %d", f.NumChannels(), test.wantChannels)
+			}
+			if f.BytesPerSample() != test.wantBytesPerSample {
+				t.Errorf("BytesPerSample() = %d, expected %d", f.BytesPerSample(), test.wantBytesPerSample)
+			}
+			if f.SampleRate() != sampleRate {
+				t.Errorf("SampleRate() = %d, expected %d", f.SampleRate(), sampleRate)
+			}
+			if f.IsFloatPlanar() {
+				t.Errorf("IsFloatPlanar() = true")
+			}
+
+			size, duration := readAll(t, f)
+			if wantSize := sampleRate * test.wantChannels * test.wantBytesPerSample; size != wantSize {
+				t.Errorf("read %d bytes, expected %d", size, wantSize)
+			}
+			if absDuration(duration-time.Second) > time.Millisecond {
+				t.Errorf("read %v, expected 1s", duration)
+			}
+		})
+	}
+}
+
+func TestSampleFormatNegotiation(t *testing.T) {
+	tests := []struct {
+		name               string
+		format             sampleFormat
+		accepted           []string
+		wantFormat         string
+		wantBytesPerSample int
+	}{
+		{"s16 as is", formatS16, []string{"s16", "s32", "flt"}, "s16", 2},
+		{"s16 to s24", formatS16, []string{"s24", "s32"}, "s24", 3},
+		{"s16 to float", formatS16, []string{"flt"}, "flt", 4},
+		{"s32 to s24", formatS32, []string{"s16", "s24"}, "s24", 3},
+		{"float as is", formatF32, []string{"s16", "flt"}, "flt", 4},
+		{"float to planar", formatF32, []string{"fltp"}, "fltp", 4},
+		{"float to s32", formatF32, []string{"s16", "s32"}, "s32", 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			const samples = 4800
+			file := createWAV(t, fixture{channels: 2, format: test.format, sampleRate: 48000, samples: samples})
+			f, err := CreateWithOptions(file, Options{MaxChannels: 2, SampleFormats: test.accepted})
+			if err != nil {
+				t.Fatalf("CreateWithOptions: %v", err)
+			}
+			defer f.Close()
+
+			if f.SampleFormat() != test.wantFormat {
+				t.Errorf("SampleFormat() = %q, expected %q", f.SampleFormat(), test.wantFormat)
+			}
+			if f.BytesPerSample() != test.wantBytesPerSample {
+				t.Errorf("BytesPerSample() = %d, expected %d", f.BytesPerSample(), test.wantBytesPerSample)
+			}
+			if f.IsFloatPlanar() != (test.wantFormat == "fltp") {
+				t.Errorf("IsFloatPlanar() = %v", f.IsFloatPlanar())
+			}
+			if size, _ := readAll(t, f); size != samples*2*test.wantBytesPerSample {
+				t.Errorf("read %d bytes, expected %d", size, samples*2*test.wantBytesPerSample)
+			}
+		})
+	}
+}
+
+// Audio in a format that the output accepts comes out unchanged
+func TestBitPerfect(t *testing.T) {
+	f32 := fixture{channels: 2, format: formatF32, sampleRate: 48000, samples: 4800}
+	f, err := CreateWithOptions(createWAV(t, f32), Options{MaxChannels: 2, SampleFormats: []string{"flt"}})
+	if err != nil {
+		t.Fatalf("CreateWithOptions: %v", err)
+	}
+	defer f.Close()
+
+	i := 0
+	for {
+		frame, err := f.ReadAudioFrame()
+		if err != nil {
+			t.Fatalf("ReadAudioFrame: %v", err)
+		}
+		if frame == nil {
+			break
+		}
+		for j := 0; j+3 < len(frame.Data); j += 4 {
+			got := math.Float32frombits(binary.LittleEndian.Uint32(frame.Data[j:]))
+			if want := float32(f32.sine(i/2, i%2)); got != want {
+				t.Fatalf("sample %d, channel %d: got %v, expected %v", i/2, i%2, got, want)
+			}
+			i++
+		}
+	}
+	if i != 2*f32.samples {
+		t.Errorf("read %d samples, expected %d", i, 2*f32.samples)
+	}
+}
+
+// 24-bit FLAC is decoded to S32, but only has 24 bits worth keeping
+func TestS24Output(t *testing.T) {
+	wav := createWAV(t, fixture{channels: 2, format: formatS32, sampleRate: 48000, samples: 48000})
+	file := encode(t, wav, "fixture.flac", []string{"-c:a", "flac", "-sample_fmt", "s32", "-bits_per_raw_sample", "24"}, nil)
+	f, err := CreateWithOptions(file, Options{MaxChannels: 2, SampleFormats: []string{"s16", "s24", "s32", "flt"}})
+	if err != nil {
+		t.Fatalf("CreateWithOptions: %v", err)
+	}
+	defer f.Close()
+	if f.SampleFormat() != "s24" {
+		t.Errorf("SampleFormat() = %q, expected s24", f.SampleFormat())
+	}
+	if size, _ := readAll(t, f); size != 48000*2*3 {
+		t.Errorf("read %d bytes, expected %d", size, 48000*2*3)
+	}
+}
+
+func TestResampling(t *testing.T) {
+	file := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 5 * 44100})
+	f, err := CreateWithOptions(file, Options{MaxChannels: 2, SampleRates: []int{48000, 96000}})
+	if err != nil {
+		t.Fatalf("CreateWithOptions: %v", err)
+	}
+	defer f.Close()
+
+	if f.SampleRate() != 48000 {
+		t.Errorf("SampleRate() = %d, expected 48000", f.SampleRate())
+	}
+	conversion := f.Conversion()
+	if conversion.InputRate != 44100 || conversion.OutputRate != 48000 || conversion.Resampler == "" || conversion.BitPerfect {
+		t.Errorf("Conversion() = %v", conversion)
+	}
+
+	size, duration := readAll(t, f)
+	if absDuration(duration-5*time.Second) > time.Millisecond {
+		t.Errorf("read %v, expected 5s", duration)
+	}
+	if wantSize := 5 * 48000 * 2 * 2; math.Abs(float64(size-wantSize)) > 0.001*float64(wantSize) {
+		t.Errorf("read %d bytes, expected about %d", size, wantSize)
+	}
+
+	testSeek(t, f)
+}
+
+func TestNoConversion(t *testing.T) {
+	file := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 4410})
+	conversion := open(t, file, 2).Conversion()
+	if !conversion.BitPerfect || conversion.Resampler != "" || conversion.RemapChannels {
+		t.Errorf("Conversion() = %v", conversion)
+	}
+}
+
+func TestChooseSampleRate(t *testing.T) {
+	tests := []struct {
+		rate     int
+		accepted []int
+		want     int
+	}{
+		{44100, nil, 44100},
+		{44100, []int{48000}, 48000},
+		{44100, []int{96000, 48000}, 48000},
+		{192000, []int{44100, 48000, 96000}, 96000},
+		{48000, []int{44100, 48000}, 48000},
+	}
+	for _, test := range tests {
+		if got := chooseSampleRate(test.rate, test.accepted); got != test.want {
+			t.Errorf("chooseSampleRate(%d, %v) = %d, expected %d", test.rate, test.accepted, got, test.want)
+		}
+	}
+}
+
+func TestChooseSampleFormat(t *testing.T) {
+	tests := []struct {
+		natural  string
+		accepted []string
+		want     string
+	}{
+		{"s16", nil, "s16"},
+		{"fltp", nil, "s32"},
+		{"s24", nil, "s32"},
+		{"s16", []string{"s24", "flt"}, "s24"},
+		{"fltp", []string{"s16", "flt"}, "flt"},
+		{"s24", []string{"s16", "s24", "s32", "flt"}, "s24"},
+		{"s32", []string{"u8", "s16"}, "s16"},
+		{"s16", []string{"unknown"}, ""},
+	}
+	for _, test := range tests {
+		if got := chooseSampleFormat(test.natural, test.accepted); got != test.want {
+			t.Errorf("chooseSampleFormat(%q, %v) = %q, expected %q", test.natural, test.accepted, got, test.want)
+		}
+	}
+}
+
+// Fixture with a distinct constant value in each channel
+func channelsFixture(channels int) fixture {
+	return fixture{
+		channels:   channels,
+		format:     formatS16,
+		sampleRate: 48000,
+		samples:    4800,
+		sample: func(i int, channel int) float64 {
+			return float64(1000*(channel+1)) / math.MaxInt16
+		},
+	}
+}
+
+// Checks that each sample of the first frame has the given channel values
+func checkChannelValues(t *testing.T, f *FFmpeg, want []int16) {
+	t.Helper()
+	if f.NumChannels() != len(want) {
+		t.Fatalf("NumChannels() = %d, expected %d", f.NumChannels(), len(want))
+	}
+	frame, err := f.ReadAudioFrame()
+	if err != nil || frame == nil {
+		t.Fatalf("ReadAudioFrame: %v, %v", frame, err)
+	}
+	frameSize := 2 * len(want)
+	for i := 0; i+frameSize <= len(frame.Data); i += frameSize {
+		for channel, value := range want {
+			if got := int16(binary.LittleEndian.Uint16(frame.Data[i+2*channel:])); got != value {
+				t.Fatalf("sample %d, channel %d: got %d, expected %d", i/frameSize, channel, got, value)
+			}
+		}
+	}
+}
+
+func TestChannelOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		channels     int
+		order        []string
+		want         []int16
+		wantChannels string
+	}{
+		// The default order swaps FC and LFE
+		{"5.1 default", 6, nil, []int16{1000, 2000, 4000, 3000, 5000, 6000}, "FL FR LFE FC BL BR"},
+		{"7.1 default", 8, nil, []int16{1000, 2000, 4000, 3000, 5000, 6000, 7000, 8000}, "FL FR LFE FC BL BR SL SR"},
+		{"5.1 native", 6, []string{"FL", "FR", "FC", "LFE", "BL", "BR"}, []int16{1000, 2000, 3000, 4000, 5000, 6000}, "FL FR FC LFE BL BR"},
+		// Channels that aren't in the order come last
+		{"5.1 partial", 6, []string{"FC", "FL", "FR"}, []int16{3000, 1000, 2000, 4000, 5000, 6000}, "FC FL FR LFE BL BR"},
+		{"stereo", 2, nil, []int16{1000, 2000}, "FL FR"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := createWAV(t, channelsFixture(test.channels))
+			f, err := CreateWithOptions(file, Options{MaxChannels: 8, ChannelOrder: test.order})
+			if err != nil {
+				t.Fatalf("CreateWithOptions: %v", err)
+			}
+			defer f.Close()
+			if channels := strings.Join(f.Conversion().Channels, " "); channels != test.wantChannels {
+				t.Errorf("Conversion().Channels = %s, expected %s", channels, test.wantChannels)
+			}
+			checkChannelValues(t, f, test.want)
+		})
+	}
+}
+
+func TestDownmix(t *testing.T) {
+	minus3 := -3.0
+	tests := []struct {
+		name         string
+		channels     int
+		maxChannels  int
+		downmix      Downmix
+		wantChannels int
+		wantLayout   string
+	}{
+		{"7.1 to 5.1", 8, 6, Downmix{}, 6, "5.1"},
+		{"7.1 to quad", 8, 4, Downmix{}, 4, "quad"},
+		{"5.1 to stereo", 6, 2, Downmix{}, 2, "stereo"},
+		{"5.1 with LFE", 6, 2, Downmix{LFELevel: &minus3, CenterLevel: &minus3}, 2, "stereo"},
+		{"5.1 to Pro Logic II", 6, 4, Downmix{Matrix: "dplii"}, 2, "stereo"},
+		{"5.1 to mono", 6, 8, Downmix{Mono: true}, 1, "mono"},
+		{"stereo to mono", 2, 2, Downmix{Mono: true}, 1, "mono"},
+		// Nothing to mix down
+		{"5.1 as is", 6, 6, Downmix{Matrix: "dplii"}, 6, "5.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := createWAV(t, channelsFixture(test.channels))
+			f, err := CreateWithOptions(file, Options{MaxChannels: test.maxChannels, Downmix: test.downmix})
+			if err != nil {
+				t.Fatalf("CreateWithOptions: %v", err)
+			}
+			defer f.Close()
+			if f.NumChannels() != test.wantChannels {
+				t.Errorf("NumChannels() = %d, expected %d", f.NumChannels(), test.wantChannels)
+			}
+			if layout := f.Conversion().OutputLayout; layout != test.wantLayout {
+				t.Errorf("OutputLayout = %s, expected %s", layout, test.wantLayout)
+			}
+			if size, _ := readAll(t, f); size != 4800*test.wantChannels*2 {
+				t.Errorf("read %d bytes, expected %d", size, 4800*test.wantChannels*2)
+			}
+		})
+	}
+}
+
+func TestInvalidChannelOptions(t *testing.T) {
+	file := createWAV(t, channelsFixture(6))
+	for _, options := range []Options{
+		{MaxChannels: 8, ChannelOrder: []string{"FL", "NOPE"}},
+		{MaxChannels: 2, Downmix: Downmix{Matrix: "nope"}},
+	} {
+		if f, err := CreateWithOptions(file, options); err == nil {
+			f.Close()
+			t.Errorf("CreateWithOptions(%+v) succeeded", options)
+		}
+	}
+}
+
+func testSeek(t *testing.T, f *FFmpeg) {
+	t.Helper()
+	for _, position := range []time.Duration{2500 * time.Millisecond, 500 * time.Millisecond, 4 * time.Second, 0} {
+		if err := f.Seek(position); err != nil {
+			t.Fatalf("Seek(%v): %v", position, err)
+		}
+		frame, err := f.ReadAudioFrame()
+		if err != nil || frame == nil {
+			t.Fatalf("ReadAudioFrame after Seek(%v): %v, %v", position, frame, err)
+		}
+		if absDuration(frame.Position-position) > time.Millisecond {
+			t.Errorf("Seek(%v): first frame at %v", position, frame.Position)
+		}
+	}
+}
+
+// After seeking, the first sample is the one at the target. Each sample holds
+// its index, so the first one read tells where the seek landed.
+func TestSeekIsSampleAccurate(t *testing.T) {
+	const sampleRate = 48000
+	wav := createWAV(t, fixture{
+		channels:   1,
+		format:     formatS16,
+		sampleRate: sampleRate,
+		samples:    5 * sampleRate,
+		sample: func(i int, channel int) float64 {
+			return float64(i%30000) / math.MaxInt16
+		},
+	})
+	files := map[string]string{"wav": wav}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		files["flac"] = encode(t, wav, "fixture.flac", []string{"-c:a", "flac"}, nil)
+	}
+	for name, file := range files {
+		t.Run(name, func(t *testing.T) {
+			f := open(t, file, 2)
+			for _, sample := range []int{72000, 1, 100003, 12345} {
+				position := time.Duration(sample) * time.Second / sampleRate
+				if err := f.Seek(position); err != nil {
+					t.Fatalf("Seek(%v): %v", position, err)
+				}
+				frame, err := f.ReadAudioFrame()
+				if err != nil || frame == nil {
+					t.Fatalf("ReadAudioFrame after Seek(%v): %v, %v", position, frame, err)
+				}
+				if got := int(int16(binary.LittleEndian.Uint16(frame.Data))); got != sample%30000 {
+					t.Errorf("Seek(%v): first sample %d, expected %d", position, got, sample%30000)
+				}
+				if frame.Position != position {
+					t.Errorf("Seek(%v): first frame at %v", position, frame.Position)
+				}
+			}
+		})
+	}
+}
+
+func TestSeekPCM(t *testing.T) {
+	file := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 5 * 44100})
+	testSeek(t, open(t, file, 2))
+}
+
+func TestReader(t *testing.T) {
+	file := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 5 * 44100})
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSize, wantDuration := readAll(t, open(t, file, 2))
+
+	osFile, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFile.Close()
+	readers := []struct {
+		name     string
+		fileName string
+		reader   io.ReadSeeker
+	}{
+		{"bytes", "fixture.wav", bytes.NewReader(data)},
+		{"file", "fixture.wav", osFile},
+		// Without a name the format is detected from the content
+		{"section", "", io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))},
+	}
+	for _, test := range readers {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := CreateFromReader(test.fileName, test.reader, Options{MaxChannels: 2})
+			if err != nil {
+				t.Fatalf("CreateFromReader: %v", err)
+			}
+			defer f.Close()
+			if size, duration := readAll(t, f); size != wantSize || duration != wantDuration {
+				t.Errorf("read %d bytes, %v, expected %d bytes, %v", size, duration, wantSize, wantDuration)
+			}
+			testSeek(t, f)
+		})
+	}
+}
+
+func TestReaderInvalidData(t *testing.T) {
+	if f, err := CreateFromReader("", bytes.NewReader([]byte("not audio")), Options{MaxChannels: 2}); err == nil {
+		f.Close()
+		t.Error("CreateFromReader succeeded")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		codecArgs   []string
+		wantCodec   string
+		wantProfile string
+	}{
+		{"flac", "fixture.flac", []string{"-c:a", "flac"}, "flac", ""},
+		{"alac", "fixture.m4a", []string{"-c:a", "alac"}, "alac", ""},
+		// Planar float
+		{"aac", "fixture.m4a", []string{"-c:a", "aac", "-b:a", "128k"}, "aac", "LC"},
+		{"mp2", "fixture.mp2", []string{"-c:a", "mp2"}, "mp2", ""},
+		{"vorbis", "fixture.ogg", []string{"-c:a", "libvorbis"}, "vorbis", ""},
+		{"ac3 in matroska", "fixture.mka", []string{"-c:a", "ac3"}, "ac3", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wav := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 48000, samples: 2 * 48000})
+			f := open(t, encode(t, wav, test.file, test.codecArgs, nil), 2)
+
+			if codec, profile := f.Codec(); codec != test.wantCodec || profile != test.wantProfile {
+				t.Errorf("Codec() = %q, %q, expected %q, %q", codec, profile, test.wantCodec, test.wantProfile)
+			}
+			if f.NumChannels() != 2 || f.SampleRate() != 48000 {
+				t.Errorf("got %d channels at %d Hz", f.NumChannels(), f.SampleRate())
+			}
+
+			size, duration := readAll(t, f)
+			// Lossy encoders add a few frames of padding
+			if absDuration(duration-2*time.Second) > 100*time.Millisecond {
+				t.Errorf("read %v, expected 2s", duration)
+			}
+			frameSize := f.NumChannels() * f.BytesPerSample()
+			if size%frameSize != 0 {
+				t.Errorf("read %d bytes, not a multiple of %d", size, frameSize)
+			}
+			if samples := time.Duration(size/frameSize) * time.Second / 48000; absDuration(samples-duration) > time.Millisecond {
+				t.Errorf("read %v of samples, but frame durations add up to %v", samples, duration)
+			}
+		})
+	}
+}
+
+// Lossless codecs must decode to the very last sample, including what the
+// decoder buffers at the end of the stream
+func TestDecodesToLastSample(t *testing.T) {
+	const samples = 48000 + 1234
+	for _, test := range []struct {
+		file      string
+		codecArgs []string
+	}{
+		{"fixture.flac", []string{"-c:a", "flac"}},
+		{"fixture.m4a", []string{"-c:a", "alac"}},
+		{"fixture.wv", []string{"-c:a", "wavpack"}},
+	} {
+		t.Run(test.codecArgs[1], func(t *testing.T) {
+			wav := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 48000, samples: samples})
+			f := open(t, encode(t, wav, test.file, test.codecArgs, nil), 2)
+			size, _ := readAll(t, f)
+			if wantSize := samples * 2 * f.BytesPerSample(); size != wantSize {
+				t.Errorf("read %d bytes, expected %d", size, wantSize)
+			}
+		})
+	}
+}
+
+func TestSelectAudioStream(t *testing.T) {
+	wav := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 44100})
+	file := encode(t, wav, "fixture.mka", []string{"-map", "0:a", "-map", "0:a", "-c:a", "flac", "-metadata:s:a:0", "language=eng", "-metadata:s:a:1", "language=nld"}, nil)
+
+	one := 1
+	tests := []struct {
+		name    string
+		options Options
+		want    int
+	}{
+		{"default", Options{MaxChannels: 2}, 0},
+		{"language", Options{MaxChannels: 2, Languages: []string{"nld", "eng"}}, 1},
+		{"unknown language", Options{MaxChannels: 2, Languages: []string{"fra"}}, 0},
+		{"index", Options{MaxChannels: 2, AudioStream: &one, Languages: []string{"eng"}}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := CreateWithOptions(file, test.options)
+			if err != nil {
+				t.Fatalf("CreateWithOptions: %v", err)
+			}
+			defer f.Close()
+			if got := f.AudioStream(); got != test.want {
+				t.Errorf("AudioStream() = %d, expected %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChapters(t *testing.T) {
+	wav := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 5 * 44100})
+	chapters := []chapterFixture{{"One", 0, 2000}, {"Two", 2000, 5000}}
+	file := encode(t, wav, "fixture.mka", []string{"-c:a", "flac"}, chapters)
+
+	info, err := Probe(file)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	checkChapters(t, info.Chapters, chapters)
+
+	testSeek(t, open(t, file, 2))
+
+	f := open(t, file, 2)
+	if _, duration := readAll(t, f); absDuration(duration-5*time.Second) > time.Millisecond {
+		t.Errorf("read %v, expected 5s", duration)
+	}
+}
+
+func checkChapters(t *testing.T, got []ChapterInfo, want []chapterFixture) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d chapters, expected %d", len(got), len(want))
+	}
+	for i, chapter := range want {
+		if got[i].Title != chapter.title || got[i].Start != time.Duration(chapter.start)*time.Millisecond || got[i].End != time.Duration(chapter.end)*time.Millisecond {
+			t.Errorf("chapter %d = %+v, expected %+v", i, got[i], chapter)
+		}
+	}
+}
+
+func TestProbeReader(t *testing.T) {
+	data, err := ioutil.ReadFile(createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 48000, samples: 48000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ProbeReader("", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ProbeReader: %v", err)
+	}
+	if info.Format != "wav" {
+		t.Errorf("Format = %q, expected wav", info.Format)
+	}
+	if absDuration(info.Duration-time.Second) > time.Millisecond {
+		t.Errorf("Duration = %v, expected 1s", info.Duration)
+	}
+	if len(info.Streams) != 1 {
+		t.Fatalf("got %d streams, expected 1", len(info.Streams))
+	}
+	stream := info.Streams[0]
+	if stream.Type != "audio" || stream.Codec != "pcm_s16le" || stream.Channels != 2 || stream.Layout != "stereo" || stream.SampleRate != 48000 {
+		t.Errorf("unexpected stream %+v", stream)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	wav := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 5 * 44100})
+	chapters := []chapterFixture{{"One", 0, 2000}, {"Two", 2000, 5000}}
+	file := encode(t, wav, "fixture.mka", []string{"-c:a", "flac", "-metadata", "artist=Someone", "-metadata:s:a:0", "language=eng"}, chapters)
+
+	info, err := Probe(file)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if !strings.HasPrefix(info.Format, "matroska") {
+		t.Errorf("Format = %q, expected matroska", info.Format)
+	}
+	if absDuration(info.Duration-5*time.Second) > 10*time.Millisecond {
+		t.Errorf("Duration = %v, expected 5s", info.Duration)
+	}
+	if artist := info.Tags["ARTIST"] + info.Tags["artist"]; artist != "Someone" {
+		t.Errorf("artist = %q, expected Someone (tags %v)", artist, info.Tags)
+	}
+	if len(info.Streams) != 1 {
+		t.Fatalf("got %d streams, expected 1", len(info.Streams))
+	}
+	stream := info.Streams[0]
+	if stream.Type != "audio" || stream.Codec != "flac" || stream.Channels != 2 || stream.SampleRate != 44100 || stream.Language != "eng" {
+		t.Errorf("unexpected stream %+v", stream)
+	}
+	checkChapters(t, info.Chapters, chapters)
+}
+
+func TestProbeAttachedPicture(t *testing.T) {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewGray(image.Rect(0, 0, 16, 8))); err != nil {
+		t.Fatal(err)
+	}
+	coverFile := filepath.Join(t.TempDir(), "cover.png")
+	if err := ioutil.WriteFile(coverFile, cover.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wav := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 44100, samples: 44100})
+	file := encode(t, wav, "fixture.flac", []string{"-i", coverFile, "-map", "0:a", "-map", "1:v", "-c:a", "flac", "-c:v", "copy", "-disposition:v", "attached_pic"}, nil)
+
+	info, err := Probe(file)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	var picture *StreamInfo
+	for i := range info.Streams {
+		if info.Streams[i].AttachedPicture != nil {
+			picture = &info.Streams[i]
+		}
+	}
+	if picture == nil {
+		t.Fatalf("no attached picture in %+v", info.Streams)
+	}
+	if picture.Type != "video" || picture.Width != 16 || picture.Height != 8 {
+		t.Errorf("unexpected picture stream %+v", picture)
+	}
+	if !bytes.Equal(picture.AttachedPicture, cover.Bytes()) {
+		t.Errorf("attached picture differs from the cover")
+	}
+}
+
+func TestPassthroughPackets(t *testing.T) {
+	wav := createWAV(t, fixture{channels: 6, format: formatS16, sampleRate: 48000, samples: 48000})
+	f := open(t, encode(t, wav, "fixture.mka", []string{"-c:a", "ac3"}, nil), 8)
+
+	if codec, _ := f.Codec(); codec != "ac3" {
+		t.Errorf("Codec() = %q, expected ac3", codec)
+	}
+	// Each AC-3 frame holds 1536 samples
+	frameDuration := time.Duration(1536) * time.Second / 48000
+	var expectedPosition time.Duration
+	packets := 0
+	for {
+		packet, err := f.ReadAudioPacket()
+		if err != nil {
+			t.Fatalf("ReadAudioPacket: %v", err)
+		}
+		if packet == nil {
+			break
+		}
+		if len(packet.Data) == 0 {
+			t.Fatalf("packet %d is empty", packets)
+		}
+		if absDuration(packet.Duration-frameDuration) > time.Millisecond {
+			t.Errorf("packet %d: duration %v, expected %v", packets, packet.Duration, frameDuration)
+		}
+		if packets > 0 && absDuration(packet.Position-expectedPosition) > time.Millisecond {
+			t.Errorf("packet %d: position %v, expected %v", packets, packet.Position, expectedPosition)
+		}
+		expectedPosition = packet.Position + packet.Duration
+		packets++
+	}
+	if packets < 30 {
+		t.Errorf("read %d packets, expected at least 30", packets)
+	}
+}
+
+func TestTempo(t *testing.T) {
+	file := createWAV(t, fixture{channels: 2, format: formatS16, sampleRate: 48000, samples: 2 * 48000})
+	f := open(t, file, 2)
+	if err := f.SetTempo(2); err != nil {
+		t.Fatalf("SetTempo: %v", err)
+	}
+	if err := f.SetTempo(3); err == nil {
+		t.Errorf("SetTempo(3) succeeded")
+	}
+
+	size := 0
+	var last *AudioFrame
+	for {
+		frame, err := f.ReadAudioFrame()
+		if err != nil {
+			t.Fatalf("ReadAudioFrame: %v", err)
+		}
+		if frame == nil {
+			break
+		}
+		size += len(frame.Data)
+		last = frame
+	}
+	// Twice as fast means half the samples, while positions stay in media time
+	if wantSize := 48000 * 2 * 2; math.Abs(float64(size-wantSize)) > 0.1*float64(wantSize) {
+		t.Errorf("read %d bytes, expected about %d", size, wantSize)
+	}
+	if last == nil || last.Position < 1500*time.Millisecond {
+		t.Errorf("last frame at %v, expected near the end", last)
+	}
+}
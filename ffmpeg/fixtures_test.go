@@ -0,0 +1,167 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test fixtures are generated at test time: PCM WAV files are written
+// directly, and other codecs and containers are encoded from those with the
+// ffmpeg command-line tool. Tests that need the tool are skipped if it isn't
+// installed.
+
+type sampleFormat int
+
+const (
+	formatS16 sampleFormat = iota
+	formatS32
+	formatF32
+)
+
+func (f sampleFormat) bytesPerSample() int {
+	if f == formatS16 {
+		return 2
+	}
+	return 4
+}
+
+// WAVE_FORMAT_EXTENSIBLE channel masks, so that the channel layout is known
+var channelMasks = map[int]uint32{
+	1: 0x4,   // FC
+	2: 0x3,   // FL FR
+	6: 0x3f,  // FL FR FC LFE BL BR
+	8: 0x63f, // FL FR FC LFE BL BR SL SR
+}
+
+type fixture struct {
+	channels   int
+	format     sampleFormat
+	sampleRate int
+	samples    int
+	// Sample value between -1 and 1 for the given sample index and channel.
+	// Defaults to a sine with a different frequency per channel.
+	sample func(i int, channel int) float64
+}
+
+func (f fixture) sine(i int, channel int) float64 {
+	return 0.5 * math.Sin(2*math.Pi*float64(220*(channel+1))*float64(i)/float64(f.sampleRate))
+}
+
+// Writes the fixture as a WAVE_FORMAT_EXTENSIBLE file
+func writeWAV(path string, f fixture) error {
+	sample := f.sample
+	if sample == nil {
+		sample = f.sine
+	}
+	mask, ok := channelMasks[f.channels]
+	if !ok {
+		return fmt.Errorf("unsupported number of channels: %d", f.channels)
+	}
+	bytesPerSample := f.format.bytesPerSample()
+	blockAlign := f.channels * bytesPerSample
+	dataSize := f.samples * blockAlign
+	subFormat := uint32(1)
+	if f.format == formatF32 {
+		subFormat = 3
+	}
+
+	var header []byte
+	put16 := func(v uint16) { header = appendLE(header, uint32(v), 2) }
+	put32 := func(v uint32) { header = appendLE(header, v, 4) }
+	header = append(header, "RIFF"...)
+	put32(uint32(4 + 8 + 40 + 8 + dataSize))
+	header = append(header, "WAVEfmt "...)
+	put32(40)
+	put16(0xfffe)
+	put16(uint16(f.channels))
+	put32(uint32(f.sampleRate))
+	put32(uint32(f.sampleRate * blockAlign))
+	put16(uint16(blockAlign))
+	put16(uint16(bytesPerSample * 8))
+	put16(22)
+	put16(uint16(bytesPerSample * 8))
+	put32(mask)
+	put32(subFormat)
+	header = append(header, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71)
+	header = append(header, "data"...)
+	put32(uint32(dataSize))
+
+	data := make([]byte, 0, dataSize)
+	for i := 0; i < f.samples; i++ {
+		for channel := 0; channel < f.channels; channel++ {
+			v := sample(i, channel)
+			switch f.format {
+			case formatS16:
+				data = appendLE(data, uint32(int16(math.Round(v*math.MaxInt16))), 2)
+			case formatS32:
+				data = appendLE(data, uint32(int32(math.Round(v*math.MaxInt32))), 4)
+			case formatF32:
+				data = appendLE(data, math.Float32bits(float32(v)), 4)
+			}
+		}
+	}
+	return ioutil.WriteFile(path, append(header, data...), 0644)
+}
+
+func appendLE(data []byte, v uint32, size int) []byte {
+	for i := 0; i < size; i++ {
+		data = append(data, byte(v>>(8*uint(i))))
+	}
+	return data
+}
+
+// Writes the fixture to a WAV file in the test's temporary directory
+func createWAV(t *testing.T, f fixture) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.wav")
+	if err := writeWAV(path, f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type chapterFixture struct {
+	title string
+	// Start and end in milliseconds
+	start int
+	end   int
+}
+
+// Encodes a WAV file with the ffmpeg tool, adding chapters if given.
+// The output format follows from the extension of name.
+func encode(t *testing.T, wav string, name string, codecArgs []string, chapters []chapterFixture) string {
+	t.Helper()
+	tool, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg tool not installed")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, name)
+	args := []string{"-v", "error", "-y", "-i", wav}
+	if len(chapters) > 0 {
+		metadata := ";FFMETADATA1\n"
+		for _, chapter := range chapters {
+			metadata += fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", chapter.start, chapter.end, chapter.title)
+		}
+		metadataFile := filepath.Join(dir, "metadata.txt")
+		if err := ioutil.WriteFile(metadataFile, []byte(metadata), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "-i", metadataFile, "-map", "0:a", "-map_chapters", "1")
+	}
+	args = append(args, codecArgs...)
+	args = append(args, out)
+	cmd := exec.Command(tool, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "Unknown encoder") || strings.Contains(string(output), "Encoder not found") {
+			t.Skipf("encoder not available: %s", output)
+		}
+		t.Fatalf("ffmpeg %v: %v\n%s", args, err, output)
+	}
+	return out
+}
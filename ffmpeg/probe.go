@@ -0,0 +1,135 @@
+package ffmpeg
+
+/*
+#include <libavformat/avformat.h>
+#include <libavutil/dict.h>
+
+extern const int64_t noPTS;
+*/
+import "C"
+
+import (
+	"io"
+	"time"
+	"unsafe"
+)
+
+// MediaInfo describes a file, as found by Probe
+type MediaInfo struct {
+	// Short name of the container format, such as "matroska,webm"
+	Format string
+	// Descriptive name of the container format, such as "Matroska / WebM"
+	LongFormat string
+	// -1 when unknown
+	Duration time.Duration
+	BitRate  int64
+	// Global tags, with keys as stored in the file
+	Tags     map[string]string
+	Streams  []StreamInfo
+	Chapters []ChapterInfo
+}
+
+type ChapterInfo struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+	Tags  map[string]string
+}
+
+// Probe reads the format, streams, tags and chapters of a file without
+// setting up decoding.
+func Probe(file string) (*MediaInfo, error) {
+	return probe(file, nil)
+}
+
+// ProbeReader is like Probe, but reads from reader. The name is only a hint
+// for detecting the format, and may be empty.
+func ProbeReader(name string, reader io.ReadSeeker) (*MediaInfo, error) {
+	return probe(name, reader)
+}
+
+func probe(file string, reader io.ReadSeeker) (*MediaInfo, error) {
+	formatCtx, input, err := openInput(file, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput(&formatCtx, input)
+
+	info := MediaInfo{
+		Format:     C.GoString(formatCtx.iformat.name),
+		LongFormat: C.GoString(formatCtx.iformat.long_name),
+		Duration:   rescaleToDuration(formatCtx.duration, C.AVRational{num: 1, den: C.AV_TIME_BASE}),
+		BitRate:    int64(formatCtx.bit_rate),
+		Tags:       dictionary(formatCtx.metadata),
+		Streams:    []StreamInfo{},
+		Chapters:   []ChapterInfo{},
+	}
+	streams := (*[1 << 20]*C.AVStream)(unsafe.Pointer(formatCtx.streams))[:formatCtx.nb_streams:formatCtx.nb_streams]
+	for i, stream := range streams {
+		info.Streams = append(info.Streams, streamInfo(i, stream))
+	}
+	if formatCtx.nb_chapters > 0 {
+		chapters := (*[1 << 20]*C.AVChapter)(unsafe.Pointer(formatCtx.chapters))[:formatCtx.nb_chapters:formatCtx.nb_chapters]
+		for _, chapter := range chapters {
+			tags := dictionary(chapter.metadata)
+			info.Chapters = append(info.Chapters, ChapterInfo{
+				Title: tags["title"],
+				Start: rescaleToDuration(chapter.start, chapter.time_base),
+				End:   rescaleToDuration(chapter.end, chapter.time_base),
+				Tags:  tags,
+			})
+		}
+	}
+	return &info, nil
+}
+
+func streamInfo(index int, stream *C.AVStream) StreamInfo {
+	codecpar := stream.codecpar
+	info := StreamInfo{
+		Index:    index,
+		Type:     C.GoString(C.av_get_media_type_string(codecpar.codec_type)),
+		Language: streamTag(stream, "language"),
+		Title:    streamTag(stream, "title"),
+		Codec:    C.GoString(C.avcodec_get_name(codecpar.codec_id)),
+		Profile:  C.GoString(C.avcodec_profile_name(codecpar.codec_id, codecpar.profile)),
+		Duration: rescaleToDuration(stream.duration, stream.time_base),
+		BitRate:  int64(codecpar.bit_rate),
+		Default:  stream.disposition&C.AV_DISPOSITION_DEFAULT != 0,
+		Tags:     dictionary(stream.metadata),
+	}
+	switch codecpar.codec_type {
+	case C.AVMEDIA_TYPE_AUDIO:
+		info.Channels = int(codecpar.ch_layout.nb_channels)
+		info.Layout = channelLayoutName(&codecpar.ch_layout)
+		info.SampleRate = int(codecpar.sample_rate)
+		info.BitsPerSample = int(codecpar.bits_per_raw_sample)
+	case C.AVMEDIA_TYPE_VIDEO:
+		info.Width = int(codecpar.width)
+		info.Height = int(codecpar.height)
+	}
+	if stream.disposition&C.AV_DISPOSITION_ATTACHED_PIC != 0 && stream.attached_pic.size > 0 {
+		info.AttachedPicture = C.GoBytes(unsafe.Pointer(stream.attached_pic.data), stream.attached_pic.size)
+	}
+	return info
+}
+
+func dictionary(dict *C.AVDictionary) map[string]string {
+	result := map[string]string{}
+	empty := C.CString("")
+	defer C.free(unsafe.Pointer(empty))
+	var entry *C.AVDictionaryEntry
+	for {
+		entry = C.av_dict_get(dict, empty, entry, C.AV_DICT_IGNORE_SUFFIX)
+		if entry == nil {
+			return result
+		}
+		result[C.GoString(entry.key)] = C.GoString(entry.value)
+	}
+}
+
+func rescaleToDuration(value C.int64_t, timeBase C.AVRational) time.Duration {
+	if value == C.noPTS {
+		return -1
+	}
+	return time.Duration(C.av_rescale_q(value, timeBase, C.AVRational{num: 1, den: 1e9}))
+}
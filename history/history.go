@@ -0,0 +1,142 @@
+// Package history keeps an append-only log of what was played.
+//
+// Each entry is one tab-separated line:
+//
+//	start  album  chapter  listened-seconds  finished
+//
+// Tabs, newlines and backslashes in the album are escaped as in Go strings.
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Entry struct {
+	// When playback started
+	Start time.Time
+	// File of the album
+	Album string
+	// Index of the chapter, or -1 if the album has no chapters
+	Chapter int
+	// Time spent listening, at whatever speed the album was played
+	Listened time.Duration
+	// Whether the chapter was played until the end
+	Finished bool
+}
+
+func (e Entry) String() string {
+	finished := 0
+	if e.Finished {
+		finished = 1
+	}
+	return fmt.Sprintf("%s\t%s\t%d\t%d\t%d", e.Start.Format(time.RFC3339), escapeField(e.Album), e.Chapter, int64(e.Listened/time.Second), finished)
+}
+
+var fieldEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+func escapeField(field string) string {
+	return fieldEscaper.Replace(field)
+}
+
+func unescapeField(field string) (string, error) {
+	if !strings.Contains(field, "\\") {
+		return field, nil
+	}
+	var result strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' {
+			result.WriteByte(field[i])
+			continue
+		}
+		i++
+		if i == len(field) {
+			return "", fmt.Errorf("Invalid escape at the end of %q", field)
+		}
+		switch field[i] {
+		case '\\':
+			result.WriteByte('\\')
+		case 't':
+			result.WriteByte('\t')
+		case 'n':
+			result.WriteByte('\n')
+		case 'r':
+			result.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("Invalid escape in %q", field)
+		}
+	}
+	return result.String(), nil
+}
+
+func parseEntry(line string) (Entry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 5 {
+		return Entry{}, fmt.Errorf("Invalid history entry: %q", line)
+	}
+	start, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return Entry{}, err
+	}
+	chapter, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Entry{}, err
+	}
+	listened, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return Entry{}, err
+	}
+	album, err := unescapeField(fields[1])
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Start:    start,
+		Album:    album,
+		Chapter:  chapter,
+		Listened: time.Duration(listened) * time.Second,
+		Finished: fields[4] == "1",
+	}, nil
+}
+
+// Append adds an entry to the end of the log file, creating it if necessary.
+func Append(file string, entry Entry) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(entry.String() + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns all entries in the log file. Lines that can't be parsed (e.g.
+// a line cut short by a power cut) are skipped.
+func Read(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, err := parseEntry(scanner.Text())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package history
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAppendRead(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "history.tsv")
+	start := time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC)
+	entries := []Entry{
+		{Start: start, Album: "/media/Plain.mka", Chapter: -1, Listened: 90 * time.Second, Finished: true},
+		{Start: start.Add(time.Hour), Album: "/media/Tab\there.mka", Chapter: 2, Listened: time.Second},
+		{Start: start.Add(2 * time.Hour), Album: "/media/New\nline\r.mka", Chapter: 0},
+		{Start: start.Add(3 * time.Hour), Album: `C:\media\Back\slash\t.mka`, Chapter: 1, Finished: true},
+	}
+	for _, entry := range entries {
+		if err := Append(file, entry); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	got, err := Read(file)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("Read() = %+v, expected %+v", got, entries)
+	}
+}
+
+func TestReadSkipsInvalidLines(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.tsv")
+	valid := "2024-03-01T07:30:00Z\t/media/Album.mka\t1\t60\t1\n"
+	lines := []string{
+		valid,
+		// Cut short by a power cut
+		"2024-03-01T08:00:00Z\t/media/Album.mka\t2\n",
+		"not a history entry\n",
+		"2024-03-01T08:00:00Z\t/media/Album.mka\tx\t60\t1\n",
+		"2024-03-01T08:00:00Z\t/media/Album.mka\t2\tx\t1\n",
+		"yesterday\t/media/Album.mka\t2\t60\t1\n",
+		"2024-03-01T08:00:00Z\t/media/Bad\\escape.mka\t2\t60\t1\n",
+		"2024-03-01T08:00:00Z\t/media/Trailing\\\t2\t60\t1\n",
+		"\n",
+		valid,
+		"2024-03-01T09:00:00Z\t/media/Alb",
+	}
+	data := ""
+	for _, line := range lines {
+		data += line
+	}
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(file)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	want := Entry{Start: time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC), Album: "/media/Album.mka", Chapter: 1, Listened: time.Minute, Finished: true}
+	if !reflect.DeepEqual(got, []Entry{want, want}) {
+		t.Errorf("Read() = %+v, expected two of %+v", got, want)
+	}
+}
@@ -1,5 +1,9 @@
 package jukybox
 
+import (
+	"time"
+)
+
 type Button int
 
 const (
@@ -12,4 +16,43 @@ const (
 	AButton
 	BButton
 	PowerButton
+	SpeedButton
+	VolumeUpButton
+	VolumeDownButton
+	MuteButton
+	QueueButton
+	AudioTrackButton
+	// Sent when holding the favourite button for favouriteHoldDuration
+	FavouriteButton
+	FavouritesOnlyButton
+	// Makes the number being entered select a chapter instead of an album
+	ChapterModeButton
+	// Selects the number being entered right away
+	EnterButton
+	Digit0Button
+	Digit1Button
+	Digit2Button
+	Digit3Button
+	Digit4Button
+	Digit5Button
+	Digit6Button
+	Digit7Button
+	Digit8Button
+	Digit9Button
+	// Shows the volume, for remotes whose volume keys are a mode of other keys
+	ShowVolumeButton
 )
+
+func DigitButton(digit int) Button {
+	return Digit0Button + Button(digit)
+}
+
+// Returns the digit of a digit button
+func (b Button) digit() (int, bool) {
+	if b < Digit0Button || b > Digit9Button {
+		return 0, false
+	}
+	return int(b - Digit0Button), true
+}
+
+const favouriteHoldDuration = time.Second
@@ -0,0 +1,230 @@
+package jukybox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	kidModeFadeDuration = 5 * time.Second
+	// Listening time is saved at most this often, to spare the SD card
+	kidModeSaveInterval = time.Minute
+)
+
+// Minutes since midnight during which playback is allowed. end can be before
+// start for windows that span midnight.
+type timeWindow struct {
+	start int
+	end   int
+}
+
+type kidMode struct {
+	maxVolume     float64
+	hours         []timeWindow
+	anytimeAlbums []string
+	budget        time.Duration
+	albums        []string
+	// Set when the config has errors, so that only anytime albums play
+	locked bool
+}
+
+// Kid mode fails closed: if any setting can't be parsed, only the anytime
+// albums can be played, so that a typo doesn't lift the limits. The errors
+// are reported together, with the mode that applies.
+func parseKidMode(config KidModeConfig) (*kidMode, error) {
+	mode := kidMode{
+		maxVolume:     config.MaxVolume,
+		anytimeAlbums: config.AnytimeAlbums,
+		albums:        config.Albums,
+	}
+	var errs []string
+	if mode.maxVolume <= 0 || mode.maxVolume > 1 {
+		mode.maxVolume = 1
+	}
+	for _, hours := range config.Hours {
+		window, err := parseTimeWindow(hours)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		mode.hours = append(mode.hours, window)
+	}
+	if len(config.DailyBudget) > 0 {
+		budget, err := time.ParseDuration(config.DailyBudget)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid daily budget %q: %v", config.DailyBudget, err))
+		} else {
+			mode.budget = budget
+		}
+	}
+	if len(errs) > 0 {
+		mode.locked = true
+		return &mode, errors.New(strings.Join(errs, "; "))
+	}
+	return &mode, nil
+}
+
+func parseTimeWindow(hours string) (timeWindow, error) {
+	var window timeWindow
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return window, fmt.Errorf("invalid hours %q", hours)
+	}
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return window, fmt.Errorf("invalid hours %q: %v", hours, err)
+		}
+		if i == 0 {
+			window.start = t.Hour()*60 + t.Minute()
+		} else {
+			window.end = t.Hour()*60 + t.Minute()
+		}
+	}
+	return window, nil
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+func (m *kidMode) inHours(t time.Time) bool {
+	if m.locked {
+		return false
+	}
+	if len(m.hours) == 0 {
+		return true
+	}
+	for _, window := range m.hours {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the album is one of the given titles or file names
+func albumMatches(mediaFile *MediaFile, albums []string) bool {
+	for _, album := range albums {
+		if mediaFile.title == album || mediaFile.file == album || filepath.Base(mediaFile.file) == album {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *kidMode) allowsAlbum(mediaFile *MediaFile) bool {
+	return len(m.albums) == 0 || albumMatches(mediaFile, m.albums) || albumMatches(mediaFile, m.anytimeAlbums)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// App
+////////////////////////////////////////////////////////////////////////////////
+
+func (app *App) listenedToday() time.Duration {
+	if app.savedState.ListenedDay != app.clock.Now().Format("2006-01-02") {
+		return 0
+	}
+	return app.savedState.Listened
+}
+
+// Returns why the current album can't be played now, or an empty string if
+// it can. Anytime albums are exempt from both the hours and the budget.
+func (app *App) kidModeCheck() string {
+	mode := app.kidMode
+	if mode == nil || len(app.mediaFiles) == 0 || albumMatches(app.currentFile(), mode.anytimeAlbums) {
+		return ""
+	}
+	if mode.locked {
+		return "Kid mode config error"
+	}
+	if !mode.inHours(app.clock.Now()) {
+		return "Time to sleep. Good night!"
+	}
+	if mode.budget > 0 && app.listenedToday() >= mode.budget {
+		return "That's enough for today"
+	}
+	return ""
+}
+
+// Starts playback, unless kid mode doesn't allow it.
+func (app *App) startPlaying() error {
+	if message := app.kidModeCheck(); len(message) > 0 {
+		app.kidModeMessage = message
+		return errors.New(message)
+	}
+	app.kidModeMessage = ""
+	app.playerState = Playing
+	app.startAudioPlayer()
+	return nil
+}
+
+// Called while playing, to keep track of the daily budget and to fade out
+// when a limit is hit.
+func (app *App) enforceKidMode() {
+	if app.kidMode == nil {
+		return
+	}
+
+	now := app.clock.Now()
+	if !app.listenTick.IsZero() {
+		elapsed := now.Sub(app.listenTick)
+		// Don't count gaps in playback (e.g. when the device failed)
+		if elapsed > 0 && elapsed < 5*time.Second {
+			today := now.Format("2006-01-02")
+			if app.savedState.ListenedDay != today {
+				app.savedState.ListenedDay = today
+				app.savedState.Listened = 0
+			}
+			app.savedState.Listened += elapsed
+			if now.Sub(app.listenSaved) >= kidModeSaveInterval {
+				app.savedState.save()
+				app.listenSaved = now
+			}
+		}
+	}
+	app.listenTick = now
+
+	if !app.fadeStart.IsZero() {
+		if now.Sub(app.fadeStart) >= kidModeFadeDuration {
+			log.Printf("Kid mode: %s", app.kidModeMessage)
+			app.playerState = Stopped
+			app.stopAudioPlayer()
+		}
+		return
+	}
+	if message := app.kidModeCheck(); len(message) > 0 {
+		app.kidModeMessage = message
+		app.fadeStart = now
+	}
+}
+
+// Fraction of the volume to apply while fading out
+func (app *App) fadeGain() float64 {
+	if app.fadeStart.IsZero() {
+		return 1
+	}
+	elapsed := app.clock.Now().Sub(app.fadeStart)
+	if elapsed >= kidModeFadeDuration {
+		return 0
+	}
+	return 1 - float64(elapsed)/float64(kidModeFadeDuration)
+}
+
+// Called when playback stops
+func (app *App) resetKidMode() {
+	app.fadeStart = time.Time{}
+	app.listenTick = time.Time{}
+	if app.kidMode != nil && app.kidMode.budget > 0 {
+		app.savedState.save()
+		app.listenSaved = app.clock.Now()
+	}
+}
@@ -0,0 +1,259 @@
+package jukybox
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		hours string
+		want  timeWindow
+	}{
+		{"07:00-20:00", timeWindow{7 * 60, 20 * 60}},
+		{" 19:30 - 07:15 ", timeWindow{19*60 + 30, 7*60 + 15}},
+		{"00:00-23:59", timeWindow{0, 23*60 + 59}},
+	}
+	for _, test := range tests {
+		got, err := parseTimeWindow(test.hours)
+		if err != nil {
+			t.Errorf("parseTimeWindow(%q): %v", test.hours, err)
+		} else if got != test.want {
+			t.Errorf("parseTimeWindow(%q) = %+v, expected %+v", test.hours, got, test.want)
+		}
+	}
+
+	for _, hours := range []string{"", "07:00", "7-8", "7am-8pm", "07:00-24:00", "07:00-08:00-09:00"} {
+		if _, err := parseTimeWindow(hours); err == nil {
+			t.Errorf("parseTimeWindow(%q) succeeded", hours)
+		}
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 8, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window timeWindow
+		t      time.Time
+		want   bool
+	}{
+		{timeWindow{7 * 60, 20 * 60}, day(6, 59), false},
+		{timeWindow{7 * 60, 20 * 60}, day(7, 0), true},
+		{timeWindow{7 * 60, 20 * 60}, day(19, 59), true},
+		{timeWindow{7 * 60, 20 * 60}, day(20, 0), false},
+		// Across midnight
+		{timeWindow{19 * 60, 7 * 60}, day(18, 59), false},
+		{timeWindow{19 * 60, 7 * 60}, day(19, 0), true},
+		{timeWindow{19 * 60, 7 * 60}, day(23, 59), true},
+		{timeWindow{19 * 60, 7 * 60}, day(0, 0), true},
+		{timeWindow{19 * 60, 7 * 60}, day(6, 59), true},
+		{timeWindow{19 * 60, 7 * 60}, day(7, 0), false},
+		{timeWindow{19 * 60, 7 * 60}, day(12, 0), false},
+	}
+	for _, test := range tests {
+		if got := test.window.contains(test.t); got != test.want {
+			t.Errorf("%+v.contains(%v) = %v, expected %v", test.window, test.t.Format("15:04"), got, test.want)
+		}
+	}
+}
+
+func TestParseKidMode(t *testing.T) {
+	noon := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	evening := time.Date(2024, 3, 8, 21, 0, 0, 0, time.UTC)
+
+	mode, err := parseKidMode(KidModeConfig{MaxVolume: 0.5, Hours: []string{"07:00-12:30", "14:00-20:00"}, DailyBudget: "1h30m"})
+	if err != nil {
+		t.Fatalf("parseKidMode: %v", err)
+	}
+	if mode.maxVolume != 0.5 || mode.budget != 90*time.Minute || !mode.inHours(noon) || mode.inHours(evening) {
+		t.Errorf("unexpected mode %+v", mode)
+	}
+
+	mode, err = parseKidMode(KidModeConfig{})
+	if err != nil {
+		t.Fatalf("parseKidMode: %v", err)
+	}
+	if mode.maxVolume != 1 || mode.budget != 0 || !mode.inHours(evening) {
+		t.Errorf("unexpected mode without limits %+v", mode)
+	}
+
+	// A typo doesn't lift the limits
+	for _, config := range []KidModeConfig{
+		{Hours: []string{"7am-8pm"}},
+		{Hours: []string{"07:00-20:00", "20:00-"}},
+		{Hours: []string{"07:00-20:00"}, DailyBudget: "2 hours"},
+		{DailyBudget: "2 hours"},
+	} {
+		mode, err := parseKidMode(config)
+		if err == nil {
+			t.Errorf("parseKidMode(%+v) succeeded", config)
+			continue
+		}
+		if !mode.locked || mode.inHours(noon) || mode.inHours(evening) {
+			t.Errorf("parseKidMode(%+v) = %+v, expected no hours", config, mode)
+		}
+	}
+}
+
+func TestKidModeAlbums(t *testing.T) {
+	story := &MediaFile{file: "/media/Story.mka", title: "A Story"}
+	sleep := &MediaFile{file: "/media/Sleep.mka"}
+	other := &MediaFile{file: "/media/Other.mka", title: "Other"}
+
+	mode := kidMode{albums: []string{"A Story"}, anytimeAlbums: []string{"Sleep.mka"}}
+	for _, test := range []struct {
+		file *MediaFile
+		want bool
+	}{{story, true}, {sleep, true}, {other, false}} {
+		if got := mode.allowsAlbum(test.file); got != test.want {
+			t.Errorf("allowsAlbum(%s) = %v, expected %v", test.file.file, got, test.want)
+		}
+	}
+
+	// Without an allowlist, all albums can be played
+	mode = kidMode{anytimeAlbums: []string{"Sleep.mka"}}
+	if !mode.allowsAlbum(other) {
+		t.Errorf("allowsAlbum(%s) = false without allowlist", other.file)
+	}
+}
+
+// App with the given kid mode that is playing the first of the files. It has
+// no pipeline, so it can't stop playback.
+func newKidModeApp(t *testing.T, clock Clock, mode *kidMode, files ...*MediaFile) *App {
+	return &App{
+		clock:       clock,
+		kidMode:     mode,
+		savedState:  loadSavedState(t.TempDir()),
+		mediaFiles:  files,
+		playerState: Playing,
+	}
+}
+
+func TestKidModeBudget(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 3, 8, 23, 56, 0, 0, time.UTC))
+	story := &MediaFile{file: "/media/Story.mka"}
+	sleep := &MediaFile{file: "/media/Sleep.mka"}
+	app := newKidModeApp(t, clock, &kidMode{maxVolume: 1, budget: time.Minute, anytimeAlbums: []string{"Sleep.mka"}}, story, sleep)
+
+	// Listening is counted in the steps between positions
+	app.enforceKidMode()
+	for i := 0; i < 59; i++ {
+		clock.Advance(time.Second)
+		app.enforceKidMode()
+	}
+	if listened := app.listenedToday(); listened != 59*time.Second {
+		t.Fatalf("listened %v, expected 59s", listened)
+	}
+	if message := app.kidModeCheck(); len(message) > 0 {
+		t.Fatalf("stopped within the budget: %s", message)
+	}
+
+	// Gaps don't count
+	clock.Advance(time.Minute)
+	app.enforceKidMode()
+	if listened := app.listenedToday(); listened != 59*time.Second {
+		t.Fatalf("listened %v after a gap, expected 59s", listened)
+	}
+
+	clock.Advance(time.Second)
+	app.enforceKidMode()
+	if message := app.kidModeCheck(); message != "That's enough for today" {
+		t.Errorf("kidModeCheck() = %q at the budget", message)
+	}
+	if app.fadeStart.IsZero() {
+		t.Errorf("didn't start fading out at the budget")
+	}
+	clock.Advance(kidModeFadeDuration / 2)
+	if gain := app.fadeGain(); math.Abs(gain-0.5) > 0.01 {
+		t.Errorf("fadeGain() = %v halfway through the fade, expected 0.5", gain)
+	}
+
+	// Anytime albums don't count towards the budget
+	app.currentFileIndex = 1
+	if message := app.kidModeCheck(); len(message) > 0 {
+		t.Errorf("kidModeCheck() = %q for an anytime album", message)
+	}
+	app.currentFileIndex = 0
+
+	// The budget is reset at midnight
+	clock.Set(time.Date(2024, 3, 9, 0, 0, 30, 0, time.UTC))
+	if listened := app.listenedToday(); listened != 0 {
+		t.Errorf("listened %v after midnight, expected 0", listened)
+	}
+	if message := app.kidModeCheck(); len(message) > 0 {
+		t.Errorf("kidModeCheck() = %q after midnight", message)
+	}
+}
+
+func TestKidModeHours(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 3, 8, 19, 59, 0, 0, time.UTC))
+	story := &MediaFile{file: "/media/Story.mka"}
+	sleep := &MediaFile{file: "/media/Sleep.mka"}
+	mode, err := parseKidMode(KidModeConfig{Hours: []string{"07:00-20:00"}, AnytimeAlbums: []string{"Sleep.mka"}})
+	if err != nil {
+		t.Fatalf("parseKidMode: %v", err)
+	}
+	app := newKidModeApp(t, clock, mode, story, sleep)
+	if message := app.kidModeCheck(); len(message) > 0 {
+		t.Errorf("kidModeCheck() = %q within the hours", message)
+	}
+	clock.Advance(time.Minute)
+	if message := app.kidModeCheck(); message != "Time to sleep. Good night!" {
+		t.Errorf("kidModeCheck() = %q outside the hours", message)
+	}
+	app.currentFileIndex = 1
+	if message := app.kidModeCheck(); len(message) > 0 {
+		t.Errorf("kidModeCheck() = %q for an anytime album", message)
+	}
+}
+
+func TestKidModeVolumeCap(t *testing.T) {
+	player := &fakeAudioPlayer{}
+	app := newTestApp(t, Options{
+		Config: Config{
+			MediaDirs: []string{createLibrary(t)},
+			KidMode:   &KidModeConfig{MaxVolume: 0.5},
+		},
+		AudioPlayer: player,
+	})
+	if err := app.SetVolume(1); err != nil {
+		t.Fatalf("SetVolume: %v", err)
+	}
+	if status, _ := app.State(); status.Volume != 0.5 {
+		t.Errorf("volume is %v, expected the cap of 0.5", status.Volume)
+	}
+	if err := app.Play(); err != nil {
+		t.Fatalf("Play: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for player.Volume() != 0.5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("played at volume %v, expected 0.5", player.Volume())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKidModeConfigErrorOnlyPlaysAnytimeAlbums(t *testing.T) {
+	app := newTestApp(t, Options{
+		Config: Config{
+			MediaDirs: []string{createLibrary(t)},
+			KidMode:   &KidModeConfig{Hours: []string{"7am-8pm"}, AnytimeAlbums: []string{"Two"}},
+		},
+	})
+	if err := app.SelectAlbum(findTestAlbum(t, app, "One").ID); err != nil {
+		t.Fatalf("SelectAlbum: %v", err)
+	}
+	if err := app.Play(); err == nil {
+		t.Errorf("played an album that isn't an anytime album")
+	}
+	if err := app.SelectAlbum(findTestAlbum(t, app, "Two").ID); err != nil {
+		t.Fatalf("SelectAlbum: %v", err)
+	}
+	if err := app.Play(); err != nil {
+		t.Errorf("Play: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package jukybox
 
 import (
 	"github.com/remko/go-mkvparse"
+	"github.com/remko/jukybox/ffmpeg"
 	"log"
 	"os"
 	"path/filepath"
@@ -22,6 +23,8 @@ type MediaFile struct {
 	artist   string
 	chapters []Chapter
 	duration time.Duration
+	// Set once the file turned out to be unplayable
+	err error
 }
 
 type MediaParser struct {
@@ -132,7 +135,13 @@ func parseFile(path string) (*MediaFile, error) {
 	}
 	err = mkvparse.ParseSections(file, []mkvparse.ElementID{mkvparse.InfoElement, mkvparse.TagsElement, mkvparse.ChaptersElement, mkvparse.TracksElement}, &handler)
 	if err != nil {
-		return nil, err
+		// FFmpeg is slower, but reads files that mkvparse doesn't
+		mediaFile, probeErr := probeFile(path)
+		if probeErr != nil {
+			return nil, err
+		}
+		log.Printf("%s: %v, read with FFmpeg instead", path, err)
+		return mediaFile, nil
 	}
 
 	if handler.duration >= 0 {
@@ -143,6 +152,38 @@ func parseFile(path string) (*MediaFile, error) {
 	return handler.mediaFile, nil
 }
 
+func probeFile(path string) (*MediaFile, error) {
+	info, err := ffmpeg.Probe(path)
+	if err != nil {
+		return nil, err
+	}
+	mediaFile := &MediaFile{
+		file:     path,
+		title:    findTag(info.Tags, "title"),
+		artist:   findTag(info.Tags, "artist"),
+		chapters: []Chapter{},
+		duration: info.Duration,
+	}
+	for _, chapter := range info.Chapters {
+		mediaFile.chapters = append(mediaFile.chapters, Chapter{
+			title: chapter.Title,
+			start: chapter.Start,
+			end:   chapter.End,
+		})
+	}
+	return mediaFile, nil
+}
+
+// Tag keys are stored as they are in the file, so their case varies
+func findTag(tags map[string]string, name string) string {
+	for key, value := range tags {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
 func GetMedia(sourceDirs []string) []*MediaFile {
 	mediaFiles := []*MediaFile{}
 	for _, sourceDir := range sourceDirs {
@@ -0,0 +1,108 @@
+package jukybox
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Album (or chapter) numbers are typed on the remote, and selected when no
+// digit was typed for numberEntryTimeout.
+//
+// While entering a number:
+//   - ChapterModeButton switches between album and chapter numbers, keeping
+//     the digits typed so far
+//   - EnterButton and PlayPauseButton select the number right away
+//   - Any other button cancels the entry, and then does what it normally does
+
+const (
+	numberEntryTimeout   = 1500 * time.Millisecond
+	numberEntryMaxDigits = 4
+)
+
+type numberEntry struct {
+	digits  string
+	chapter bool
+}
+
+// Handles the button if it belongs to number entry. Returns false if the
+// button should be handled as usual.
+func (app *App) handleNumberEntryButton(button Button) bool {
+	if digit, ok := button.digit(); ok {
+		if app.numberEntry == nil {
+			app.numberEntry = &numberEntry{}
+		}
+		if len(app.numberEntry.digits) < numberEntryMaxDigits {
+			app.numberEntry.digits += strconv.Itoa(digit)
+		}
+		app.numberEntryTimeout = app.clock.After(numberEntryTimeout)
+		return true
+	}
+
+	switch button {
+	case ChapterModeButton:
+		if app.numberEntry == nil {
+			app.numberEntry = &numberEntry{}
+		}
+		app.numberEntry.chapter = !app.numberEntry.chapter
+		app.numberEntryTimeout = app.clock.After(numberEntryTimeout)
+		return true
+	case EnterButton:
+		app.finishNumberEntry()
+		return true
+	case PlayPauseButton:
+		if app.numberEntry != nil {
+			app.finishNumberEntry()
+			return true
+		}
+	default:
+		app.cancelNumberEntry()
+	}
+	return false
+}
+
+func (app *App) cancelNumberEntry() {
+	app.numberEntry = nil
+	app.numberEntryTimeout = nil
+}
+
+// Selects the album or chapter that was entered
+func (app *App) finishNumberEntry() {
+	entry := app.numberEntry
+	app.cancelNumberEntry()
+	if entry == nil || len(entry.digits) == 0 {
+		return
+	}
+	n, _ := strconv.Atoi(entry.digits)
+
+	if entry.chapter {
+		chapters := app.currentFile().chapters
+		if n < 1 || n > len(chapters) {
+			app.showOverlay(fmt.Sprintf("No chapter %d", n))
+			return
+		}
+		app.queueItem = nil
+		app.setFile(app.currentFileIndex, chapters[n-1].start)
+		return
+	}
+
+	if n < 1 || n > len(app.mediaFiles) {
+		app.showOverlay(fmt.Sprintf("No album %d", n))
+		return
+	}
+	if app.mediaFiles[n-1].err != nil {
+		app.setOverlay(&Overlay{Icon: "\u26A0", Text: fmt.Sprintf("Can't play album %d", n), Level: -1})
+		return
+	}
+	app.queueItem = nil
+	app.setFile(n-1, time.Duration(0))
+}
+
+// Overlay showing the number being entered
+func (e *numberEntry) overlay() *Overlay {
+	kind := "Album"
+	if e.chapter {
+		kind = "Chapter"
+	}
+	return &Overlay{Text: fmt.Sprintf("%s %s_", kind, e.digits), Level: -1}
+}
@@ -0,0 +1,677 @@
+package jukybox
+
+import (
+	"github.com/remko/jukybox/audioplayer"
+	"github.com/remko/jukybox/ffmpeg"
+	"log"
+	"sync"
+	"time"
+)
+
+// Number of decoded frames buffered between the decoder and the audio player
+const pipelineBufferSize = 64
+
+////////////////////////////////////////////////////////////////////////////////
+// Frame buffer
+////////////////////////////////////////////////////////////////////////////////
+
+// Format of the audio sent to the audio player
+type audioFormat struct {
+	numChannels  int
+	sampleFormat string
+	sampleRate   int
+	encoding     string
+}
+
+type bufferEntry struct {
+	generation int
+	// Set on the first entry after the format changed
+	format *audioFormat
+	frame  *ffmpeg.AudioFrame
+	tempo  float64
+	// Set on the entry after the last frame of a file
+	end bool
+}
+
+type bufferStatus int
+
+const (
+	bufferOK bufferStatus = iota
+	bufferPaused
+	bufferClosed
+)
+
+// Bounded ring buffer of decoded frames.
+// Every flush starts a new generation; entries from older generations are
+// refused, so that a flush atomically discards everything decoded before it.
+type frameBuffer struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	entries    []bufferEntry
+	start      int
+	count      int
+	generation int
+	paused     bool
+	closed     bool
+}
+
+func newFrameBuffer(size int) *frameBuffer {
+	b := frameBuffer{
+		entries: make([]bufferEntry, size),
+		paused:  true,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return &b
+}
+
+// Blocks while the buffer is full. Returns false if the entry is stale.
+func (b *frameBuffer) push(entry bufferEntry) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.count == len(b.entries) && entry.generation == b.generation && !b.closed {
+		b.cond.Wait()
+	}
+	if entry.generation != b.generation || b.closed {
+		return false
+	}
+	b.entries[(b.start+b.count)%len(b.entries)] = entry
+	b.count++
+	b.cond.Broadcast()
+	return true
+}
+
+// Blocks while the buffer is empty.
+func (b *frameBuffer) pop() (bufferEntry, bufferStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.count == 0 && !b.paused && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return bufferEntry{}, bufferClosed
+	}
+	if b.paused {
+		return bufferEntry{}, bufferPaused
+	}
+	entry := b.entries[b.start]
+	b.entries[b.start] = bufferEntry{}
+	b.start = (b.start + 1) % len(b.entries)
+	b.count--
+	b.cond.Broadcast()
+	return entry, bufferOK
+}
+
+func (b *frameBuffer) waitWhilePaused() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.paused && !b.closed {
+		b.cond.Wait()
+	}
+}
+
+// Drops all buffered entries, and returns the new generation.
+func (b *frameBuffer) flush() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.entries {
+		b.entries[i] = bufferEntry{}
+	}
+	b.start = 0
+	b.count = 0
+	b.generation++
+	b.cond.Broadcast()
+	return b.generation
+}
+
+func (b *frameBuffer) setPaused(paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = paused
+	b.cond.Broadcast()
+}
+
+func (b *frameBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Pipeline
+////////////////////////////////////////////////////////////////////////////////
+
+// Pipeline event types
+const (
+	positionEvent = iota
+	endEvent
+	// The file couldn't be opened, or the audio player doesn't take its format
+	errorEvent
+	// The audio device failed (err set) or is back (err nil)
+	deviceEvent
+	// A file was opened, or its audio stream changed
+	streamsEvent
+)
+
+type pipelineEvent struct {
+	kind       int
+	generation int
+	position   time.Duration
+	err        error
+	// Audio streams in the file, and the index of the one that is played
+	streams     []ffmpeg.StreamInfo
+	audioStream int
+	// How the audio stream is converted for the audio player
+	conversion ffmpeg.Conversion
+}
+
+// Pipeline command types
+const (
+	openCommand = iota
+	seekCommand
+	tempoCommand
+	loopCommand
+	clearLoopCommand
+	audioStreamCommand
+)
+
+type pipelineCommand struct {
+	kind       int
+	generation int
+	file       string
+	position   time.Duration
+	tempo      float64
+	loopEnd    time.Duration
+	// Index of the audio stream, or -1 to select one by language
+	audioStream int
+}
+
+// Decodes audio in one goroutine and feeds it to the audio player in
+// another, so that the control loop never blocks on audio.
+// The decoder is only accessed from the decoder goroutine, and the audio
+// player only from the output goroutine.
+type pipeline struct {
+	audioPlayer audioplayer.AudioPlayer
+	buffer      *frameBuffer
+	commands    chan pipelineCommand
+	events      chan pipelineEvent
+	quit        chan struct{}
+	done        sync.WaitGroup
+
+	mu     sync.Mutex
+	volume float64
+	// Options for opening files, with the output settings of the audio player.
+	// These are read again when the device is reset, which counts as a new
+	// output version.
+	decoderOptions ffmpeg.Options
+	outputVersion  int
+}
+
+// Creates a pipeline that opens files with the given decoder options. The
+// output settings are filled in from the audio player.
+func createPipeline(audioPlayer audioplayer.AudioPlayer, decoderOptions ffmpeg.Options) *pipeline {
+	p := pipeline{
+		audioPlayer:    audioPlayer,
+		decoderOptions: decoderOptions,
+		buffer:         newFrameBuffer(pipelineBufferSize),
+		commands:       make(chan pipelineCommand, 4),
+		events:         make(chan pipelineEvent, 16),
+		quit:           make(chan struct{}),
+		volume:         1,
+	}
+	p.readOutputSettings()
+	p.done.Add(2)
+	go p.runDecoder()
+	go p.runOutput()
+	return &p
+}
+
+// Opens a file, starting at the given position. Returns the generation of the
+// frames coming from the file.
+func (p *pipeline) open(file string, position time.Duration, tempo float64, audioStream int) int {
+	generation := p.buffer.flush()
+	p.commands <- pipelineCommand{kind: openCommand, generation: generation, file: file, position: position, tempo: tempo, audioStream: audioStream}
+	return generation
+}
+
+// Switches to another audio stream of the file, continuing from the given
+// position
+func (p *pipeline) setAudioStream(audioStream int, position time.Duration) int {
+	generation := p.buffer.flush()
+	p.commands <- pipelineCommand{kind: audioStreamCommand, generation: generation, position: position, audioStream: audioStream}
+	return generation
+}
+
+func (p *pipeline) seek(position time.Duration) int {
+	generation := p.buffer.flush()
+	p.commands <- pipelineCommand{kind: seekCommand, generation: generation, position: position}
+	return generation
+}
+
+// Changes the tempo, continuing from the given position
+func (p *pipeline) setTempo(tempo float64, position time.Duration) int {
+	generation := p.buffer.flush()
+	p.commands <- pipelineCommand{kind: tempoCommand, generation: generation, position: position, tempo: tempo}
+	return generation
+}
+
+// Repeats the part between start and end, starting at start
+func (p *pipeline) setLoop(start time.Duration, end time.Duration) int {
+	generation := p.buffer.flush()
+	p.commands <- pipelineCommand{kind: loopCommand, generation: generation, position: start, loopEnd: end}
+	return generation
+}
+
+// Stops repeating, without interrupting playback
+func (p *pipeline) clearLoop() {
+	p.commands <- pipelineCommand{kind: clearLoopCommand}
+}
+
+func (p *pipeline) play() {
+	p.buffer.setPaused(false)
+}
+
+func (p *pipeline) pause() {
+	p.buffer.setPaused(true)
+}
+
+func (p *pipeline) setVolume(volume float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.volume = volume
+}
+
+func (p *pipeline) getVolume() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.volume
+}
+
+// Asks the audio player what it takes. Called from the output goroutine, or
+// before it runs.
+func (p *pipeline) readOutputSettings() {
+	maxChannels := p.audioPlayer.NumOutputChannels()
+	sampleFormats := p.audioPlayer.SampleFormats()
+	sampleRates := p.audioPlayer.SampleRates()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decoderOptions.MaxChannels = maxChannels
+	p.decoderOptions.SampleFormats = sampleFormats
+	p.decoderOptions.SampleRates = sampleRates
+	p.outputVersion++
+}
+
+// Returns the options for opening files, and the output version they are for
+func (p *pipeline) getDecoderOptions() (ffmpeg.Options, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.decoderOptions, p.outputVersion
+}
+
+func (p *pipeline) getOutputVersion() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.outputVersion
+}
+
+func (p *pipeline) close() {
+	close(p.quit)
+	p.buffer.close()
+	close(p.commands)
+	p.done.Wait()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Decoder goroutine
+////////////////////////////////////////////////////////////////////////////////
+
+type pipelineDecoder struct {
+	*pipeline
+	file        string
+	decoder     *ffmpeg.FFmpeg
+	generation  int
+	passthrough bool
+	// Output version the decoder was opened for
+	outputVersion int
+	// Format to send with the next frame
+	format *audioFormat
+	// Part to repeat, if loopEnd is set
+	loopStart time.Duration
+	loopEnd   time.Duration
+	// Whether there's nothing to decode until the next command
+	idle bool
+	// Number of decoding errors since the last good frame, and the position
+	// after that frame
+	errors       int
+	lastPosition time.Duration
+}
+
+const (
+	// Distance to skip ahead after each decoding error
+	decodeErrorSkip = time.Second
+	// Number of decoding errors in a row after which the rest of the file is
+	// skipped
+	maxDecodeErrors = 10
+)
+
+func (p *pipeline) runDecoder() {
+	defer p.done.Done()
+	d := pipelineDecoder{pipeline: p, idle: true}
+loop:
+	for {
+		if d.idle {
+			command, ok := <-p.commands
+			if !ok {
+				break loop
+			}
+			d.handleCommand(command)
+			continue
+		}
+
+		select {
+		case command, ok := <-p.commands:
+			if !ok {
+				break loop
+			}
+			d.handleCommand(command)
+		default:
+			d.decode()
+		}
+	}
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+}
+
+func (d *pipelineDecoder) handleCommand(command pipelineCommand) {
+	if command.kind == clearLoopCommand {
+		d.loopEnd = 0
+		return
+	}
+
+	d.generation = command.generation
+	d.idle = false
+	d.errors = 0
+	d.lastPosition = command.position
+	if command.kind == openCommand {
+		d.loopEnd = 0
+		if !d.open(command.file, command.audioStream, command.tempo) {
+			return
+		}
+	} else if command.kind == audioStreamCommand && d.decoder != nil {
+		if !d.open(d.file, command.audioStream, d.decoder.Tempo()) {
+			return
+		}
+	} else if d.decoder != nil && d.outputVersion != d.getOutputVersion() {
+		// The device was reset, and may take other formats now
+		if !d.open(d.file, d.decoder.AudioStream(), d.decoder.Tempo()) {
+			return
+		}
+	} else if d.decoder == nil {
+		d.idle = true
+		return
+	}
+
+	switch command.kind {
+	case tempoCommand:
+		d.setTempo(command.tempo)
+	case loopCommand:
+		d.loopStart = command.position
+		d.loopEnd = command.loopEnd
+	}
+	if command.position != 0 || command.kind != openCommand {
+		if err := d.decoder.Seek(command.position); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+}
+
+func (d *pipelineDecoder) open(file string, audioStream int, tempo float64) bool {
+	if d.decoder != nil {
+		d.decoder.Close()
+		d.decoder = nil
+	}
+	log.Printf("Opening %s", file)
+	d.file = file
+	options, outputVersion := d.getDecoderOptions()
+	if audioStream >= 0 {
+		options.AudioStream = &audioStream
+	}
+	d.outputVersion = outputVersion
+	decoder, err := ffmpeg.CreateWithOptions(file, options)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		d.idle = true
+		select {
+		case d.events <- pipelineEvent{kind: errorEvent, generation: d.generation, err: err}:
+		case <-d.quit:
+		}
+		return false
+	}
+	d.decoder = decoder
+	d.setTempo(tempo)
+	select {
+	case d.events <- pipelineEvent{kind: streamsEvent, generation: d.generation, streams: decoder.AudioStreams(), audioStream: decoder.AudioStream(), conversion: decoder.Conversion()}:
+	case <-d.quit:
+	}
+	return true
+}
+
+func (d *pipelineDecoder) setTempo(tempo float64) {
+	if err := d.decoder.SetTempo(tempo); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	// Passthrough only works when playing at normal speed
+	codec, codecProfile := d.decoder.Codec()
+	d.passthrough = d.decoder.Tempo() == 1 && audioplayer.IsPassthroughSupported(codec, codecProfile, d.decoder.SampleRate())
+	encoding := audioplayer.PCMEncoding
+	if d.passthrough {
+		encoding = codec
+	}
+	d.format = &audioFormat{
+		numChannels:  d.decoder.NumChannels(),
+		sampleFormat: d.decoder.SampleFormat(),
+		sampleRate:   d.decoder.SampleRate(),
+		encoding:     encoding,
+	}
+}
+
+func (d *pipelineDecoder) decode() {
+	var frame *ffmpeg.AudioFrame
+	var err error
+	if d.passthrough {
+		frame, err = d.decoder.ReadAudioPacket()
+	} else {
+		frame, err = d.decoder.ReadAudioFrame()
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		d.errors++
+		if d.errors < maxDecodeErrors {
+			// Skip the damaged part instead of stopping
+			d.lastPosition += decodeErrorSkip
+			log.Printf("Skipping to %v", d.lastPosition)
+			if err := d.decoder.Seek(d.lastPosition); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+			return
+		}
+		log.Printf("Too many errors, skipping the rest of the file")
+	} else if frame != nil {
+		d.errors = 0
+		d.lastPosition = frame.Position + frame.Duration
+	}
+	if frame != nil && d.loopEnd > 0 && frame.Position >= d.loopEnd {
+		d.lastPosition = d.loopStart
+		if err := d.decoder.Seek(d.loopStart); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		return
+	}
+
+	entry := bufferEntry{generation: d.generation, format: d.format, tempo: d.decoder.Tempo()}
+	if frame == nil {
+		entry.end = true
+		d.idle = true
+	} else {
+		entry.frame = frame
+	}
+	if d.buffer.push(entry) {
+		d.format = nil
+	} else {
+		// Flushed while waiting; wait for the command that comes with it
+		d.idle = true
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Output goroutine
+////////////////////////////////////////////////////////////////////////////////
+
+func (p *pipeline) runOutput() {
+	defer p.done.Done()
+	var format *audioFormat
+	started := false
+	volume := -1.0
+	failedGeneration := -1
+	// Generation of the last frame, and the position it started at
+	generation := -1
+	var generationStart time.Duration
+	for {
+		entry, status := p.buffer.pop()
+		switch status {
+		case bufferClosed:
+			if started {
+				p.audioPlayer.Stop()
+			}
+			return
+		case bufferPaused:
+			if started {
+				p.audioPlayer.Stop()
+				started = false
+			}
+			p.buffer.waitWhilePaused()
+			continue
+		}
+		if entry.generation == failedGeneration {
+			// Dropped; the control loop seeks back to where the device failed
+			continue
+		}
+
+		if entry.format != nil && (format == nil || *entry.format != *format) {
+			if started {
+				p.audioPlayer.Stop()
+				started = false
+			}
+			format = entry.format
+		}
+		if entry.end {
+			select {
+			case p.events <- pipelineEvent{kind: endEvent, generation: entry.generation}:
+			case <-p.quit:
+			}
+			continue
+		}
+		if format == nil {
+			continue
+		}
+
+		if !started {
+			if err := p.audioPlayer.Start(format.numChannels, format.sampleFormat, format.sampleRate, format.encoding); err != nil {
+				failedGeneration = entry.generation
+				if _, ok := err.(*audioplayer.FormatError); ok {
+					// The device works, it just doesn't play this file
+					log.Printf("ERROR: %v", err)
+					select {
+					case p.events <- pipelineEvent{kind: errorEvent, generation: entry.generation, err: err}:
+					case <-p.quit:
+					}
+					continue
+				}
+				p.recoverDevice(err)
+				continue
+			}
+			started = true
+			volume = -1
+		}
+		if v := p.getVolume(); v != volume {
+			p.audioPlayer.SetVolume(v)
+			volume = v
+		}
+		if err := p.audioPlayer.Write(entry.frame.Data); err != nil {
+			failedGeneration = entry.generation
+			started = false
+			p.recoverDevice(err)
+			continue
+		}
+
+		// The audio from before a seek was dropped, so nothing before the first
+		// frame of the generation can be audible
+		if entry.generation != generation {
+			generation = entry.generation
+			generationStart = entry.frame.Position
+		}
+		position := audiblePosition(entry, p.audioPlayer.Latency())
+		if position < generationStart {
+			position = generationStart
+		}
+
+		// Position updates are only informative, so drop them if the control
+		// loop is busy
+		select {
+		case p.events <- pipelineEvent{kind: positionEvent, generation: entry.generation, position: position}:
+		default:
+		}
+	}
+}
+
+const (
+	deviceRetryMinDelay = time.Second
+	deviceRetryMaxDelay = 30 * time.Second
+)
+
+// Reopens the audio device until it works again, backing off between
+// attempts. Returns early when the pipeline is closed.
+func (p *pipeline) recoverDevice(err error) {
+	log.Printf("ERROR: Audio device failed: %v", err)
+	select {
+	case p.events <- pipelineEvent{kind: deviceEvent, err: err}:
+	case <-p.quit:
+		return
+	}
+	delay := deviceRetryMinDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-p.quit:
+			return
+		}
+		err := p.audioPlayer.Reset()
+		if err == nil {
+			break
+		}
+		log.Printf("ERROR: Reopening audio device: %v", err)
+		delay *= 2
+		if delay > deviceRetryMaxDelay {
+			delay = deviceRetryMaxDelay
+		}
+	}
+	log.Printf("Audio device is back")
+	// It may be another device, or one that was missing at startup
+	p.readOutputSettings()
+	select {
+	case p.events <- pipelineEvent{kind: deviceEvent}:
+	case <-p.quit:
+	}
+}
+
+// Position (in media time) of the sample coming out of the speakers, given
+// that the entry was just written to the audio player.
+func audiblePosition(entry bufferEntry, latency time.Duration) time.Duration {
+	position := entry.frame.Position + entry.frame.Duration - time.Duration(float64(latency)*entry.tempo)
+	if position < 0 {
+		return 0
+	}
+	return position
+}
@@ -0,0 +1,220 @@
+package jukybox
+
+import (
+	"errors"
+	"fmt"
+	"github.com/remko/jukybox/ffmpeg"
+	"time"
+)
+
+var ErrNoMedia = errors.New("jukybox: no media")
+
+// Album in the library
+type Album struct {
+	// File of the album, which identifies it in the API
+	ID       string
+	Title    string
+	Artist   string
+	Duration time.Duration
+	Chapters []AlbumChapter
+}
+
+type AlbumChapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Snapshot of the player state
+type PlayerStatus struct {
+	State PlayerState
+	// ID of the current album
+	Album  string
+	Title  string
+	Artist string
+	// Index of the current chapter, or -1 if the album has no chapters
+	Chapter      int
+	ChapterTitle string
+	Position     time.Duration
+	Duration     time.Duration
+	Volume       float64
+	Muted        bool
+	Speed        float64
+	// How the audio is converted for the audio player
+	Conversion ffmpeg.Conversion
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// App API
+////////////////////////////////////////////////////////////////////////////////
+
+// Runs f on the control loop if there is media to control
+func (app *App) callWithMedia(f func() error) error {
+	return app.call(func() error {
+		if len(app.mediaFiles) == 0 {
+			return ErrNoMedia
+		}
+		return f()
+	})
+}
+
+func (app *App) Play() error {
+	return app.callWithMedia(func() error {
+		if err := app.currentFile().err; err != nil {
+			return err
+		}
+		if app.playerState != Playing {
+			return app.startPlaying()
+		}
+		return nil
+	})
+}
+
+func (app *App) Pause() error {
+	return app.callWithMedia(func() error {
+		if app.playerState != Stopped {
+			app.playerState = Stopped
+			app.stopAudioPlayer()
+		}
+		return nil
+	})
+}
+
+// Seek jumps to a position in the current album.
+func (app *App) Seek(position time.Duration) error {
+	return app.callWithMedia(func() error {
+		duration := app.currentFile().duration
+		if position < 0 || (duration > 0 && position >= duration) {
+			return fmt.Errorf("Invalid position: %v", position)
+		}
+		app.setFile(app.currentFileIndex, position)
+		return nil
+	})
+}
+
+// SelectAlbum starts the album with the given ID from the beginning.
+func (app *App) SelectAlbum(id string) error {
+	return app.callWithMedia(func() error {
+		mediaFile, ok := app.mediaFilesByFile[id]
+		if !ok {
+			return fmt.Errorf("Unknown album: %s", id)
+		}
+		if err := mediaFile.file.err; err != nil {
+			return err
+		}
+		app.queueItem = nil
+		app.setFile(mediaFile.index, time.Duration(0))
+		return nil
+	})
+}
+
+func (app *App) NextAlbum() error {
+	return app.callWithMedia(func() error {
+		app.advanceFile(1, true)
+		return nil
+	})
+}
+
+func (app *App) PreviousAlbum() error {
+	return app.callWithMedia(func() error {
+		app.advanceFile(-1, true)
+		return nil
+	})
+}
+
+func (app *App) NextChapter() error {
+	return app.callWithMedia(func() error {
+		app.advanceChapter(1)
+		return nil
+	})
+}
+
+func (app *App) PreviousChapter() error {
+	return app.callWithMedia(func() error {
+		app.advanceChapter(-1)
+		return nil
+	})
+}
+
+// SetVolume sets the volume, between 0 and 1, and unmutes.
+func (app *App) SetVolume(volume float64) error {
+	if volume < 0 || volume > 1 {
+		return fmt.Errorf("Invalid volume: %v", volume)
+	}
+	return app.call(func() error {
+		app.changeVolume(volume - app.savedState.Volume)
+		return nil
+	})
+}
+
+func (app *App) SetMuted(muted bool) error {
+	return app.call(func() error {
+		app.muted = muted
+		app.updateVolume()
+		app.showVolumeOverlay()
+		return nil
+	})
+}
+
+// SetSpeed sets the playback speed of the current album, between 0.5 and 2.
+func (app *App) SetSpeed(speed float64) error {
+	if speed < 0.5 || speed > 2 {
+		return fmt.Errorf("Invalid speed: %v", speed)
+	}
+	return app.callWithMedia(func() error {
+		app.setSpeed(speed)
+		return nil
+	})
+}
+
+func (app *App) State() (PlayerStatus, error) {
+	var status PlayerStatus
+	err := app.call(func() error {
+		status = PlayerStatus{
+			State:   app.playerState,
+			Chapter: -1,
+			Volume:  app.savedState.Volume,
+			Muted:   app.muted,
+			Speed:   1,
+		}
+		if len(app.mediaFiles) == 0 {
+			return nil
+		}
+		mediaFile := app.currentFile()
+		status.Album = mediaFile.file
+		status.Title = mediaFile.title
+		status.Artist = mediaFile.artist
+		status.Position = app.currentPosition
+		status.Duration = mediaFile.duration
+		status.Speed = app.speed()
+		status.Conversion = app.conversion
+		if chapter, chapterIndex, ok := findChapter(mediaFile, app.currentPosition); ok {
+			status.Chapter = chapterIndex
+			status.ChapterTitle = chapter.title
+		}
+		return nil
+	})
+	return status, err
+}
+
+// Albums lists the albums in the library, in library order.
+func (app *App) Albums() ([]Album, error) {
+	var albums []Album
+	err := app.call(func() error {
+		albums = make([]Album, 0, len(app.mediaFiles))
+		for _, mediaFile := range app.mediaFiles {
+			album := Album{
+				ID:       mediaFile.file,
+				Title:    mediaFile.title,
+				Artist:   mediaFile.artist,
+				Duration: mediaFile.duration,
+			}
+			for _, chapter := range mediaFile.chapters {
+				album.Chapters = append(album.Chapters, AlbumChapter{Title: chapter.title, Start: chapter.start, End: chapter.end})
+			}
+			albums = append(albums, album)
+		}
+		return nil
+	})
+	return albums, err
+}
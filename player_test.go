@@ -0,0 +1,260 @@
+package jukybox
+
+import (
+	"fmt"
+	"github.com/remko/jukybox/audioplayer"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Plays in real time, without making a sound
+type fakeAudioPlayer struct {
+	mu             sync.Mutex
+	bytesPerSecond int
+	volume         float64
+}
+
+func (p *fakeAudioPlayer) Start(numChannels int, sampleFormat string, sampleRate int, encoding string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytesPerSecond = numChannels * audioplayer.BytesPerSample(sampleFormat) * sampleRate
+	return nil
+}
+
+func (p *fakeAudioPlayer) Stop() {}
+
+func (p *fakeAudioPlayer) NumOutputChannels() int {
+	return 2
+}
+
+func (p *fakeAudioPlayer) SampleFormats() []string {
+	return []string{"s16"}
+}
+
+func (p *fakeAudioPlayer) SampleRates() []int {
+	return nil
+}
+
+func (p *fakeAudioPlayer) Write(data []byte) error {
+	p.mu.Lock()
+	bytesPerSecond := p.bytesPerSecond
+	p.mu.Unlock()
+	time.Sleep(time.Duration(len(data)) * time.Second / time.Duration(bytesPerSecond))
+	return nil
+}
+
+func (p *fakeAudioPlayer) SetVolume(volume float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.volume = volume
+}
+
+func (p *fakeAudioPlayer) Volume() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.volume
+}
+
+func (p *fakeAudioPlayer) Latency() time.Duration {
+	return 0
+}
+
+func (p *fakeAudioPlayer) Reset() error {
+	return nil
+}
+
+// Encodes an album of the given length with the ffmpeg tool, with chapters of
+// the given lengths if any. Skips the test if the tool isn't installed.
+func createAlbum(t *testing.T, dir string, title string, duration time.Duration, chapters ...time.Duration) string {
+	t.Helper()
+	tool, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg tool not installed")
+	}
+	file := filepath.Join(dir, title+".mka")
+	args := []string{"-v", "error", "-y", "-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=440:sample_rate=44100:duration=%v", duration.Seconds())}
+	if len(chapters) > 0 {
+		metadata := ";FFMETADATA1\n"
+		var start time.Duration
+		for i, chapter := range chapters {
+			metadata += fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=Chapter %d\n", start/time.Millisecond, (start+chapter)/time.Millisecond, i+1)
+			start += chapter
+		}
+		metadataFile := filepath.Join(t.TempDir(), "metadata.txt")
+		if err := ioutil.WriteFile(metadataFile, []byte(metadata), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "-i", metadataFile, "-map", "0:a", "-map_chapters", "1")
+	}
+	args = append(args, "-c:a", "flac", "-metadata", "title="+title, file)
+	if output, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "lavfi") || strings.Contains(string(output), "Encoder not found") {
+			t.Skipf("ffmpeg tool can't create fixtures: %s", output)
+		}
+		t.Fatalf("ffmpeg %v: %v\n%s", args, err, output)
+	}
+	return file
+}
+
+// Library with an album "One" of 6s with three chapters, and an album "Two"
+// of 4s without chapters
+func createLibrary(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	createAlbum(t, dir, "One", 6*time.Second, 2*time.Second, 2*time.Second, 2*time.Second)
+	createAlbum(t, dir, "Two", 4*time.Second)
+	return dir
+}
+
+// Creates an app that plays on a fake audio player and keeps its state in a
+// temporary directory, and closes it when the test ends.
+func newTestApp(t *testing.T, options Options) *App {
+	t.Helper()
+	if options.AudioPlayer == nil {
+		options.AudioPlayer = &fakeAudioPlayer{}
+	}
+	if len(options.Config.StateDir) == 0 {
+		options.Config.StateDir = t.TempDir()
+	}
+	app, err := NewApp(options)
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() { app.Close() })
+	return app
+}
+
+func findTestAlbum(t *testing.T, app *App, title string) Album {
+	t.Helper()
+	albums, err := app.Albums()
+	if err != nil {
+		t.Fatalf("Albums: %v", err)
+	}
+	for _, album := range albums {
+		if album.Title == title {
+			return album
+		}
+	}
+	t.Fatalf("album %s not found in %+v", title, albums)
+	return Album{}
+}
+
+func TestAPIErrors(t *testing.T) {
+	app := newTestApp(t, Options{Config: Config{MediaDirs: []string{createLibrary(t)}}})
+	one := findTestAlbum(t, app, "One")
+	if len(one.Chapters) != 3 {
+		t.Fatalf("got %d chapters, expected 3", len(one.Chapters))
+	}
+	if err := app.SelectAlbum(one.ID); err != nil {
+		t.Fatalf("SelectAlbum: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"unknown album", func() error { return app.SelectAlbum("/nope.mka") }},
+		{"negative position", func() error { return app.Seek(-time.Second) }},
+		{"position at the end", func() error { return app.Seek(one.Duration) }},
+		{"position past the end", func() error { return app.Seek(time.Hour) }},
+		{"unknown queued album", func() error { return app.QueueAppend("/nope.mka", -1) }},
+		{"unknown queued chapter", func() error { return app.QueuePlayNext(one.ID, 3) }},
+		{"queue index", func() error { return app.QueueRemove(0) }},
+		{"volume", func() error { return app.SetVolume(1.5) }},
+		{"speed", func() error { return app.SetSpeed(4) }},
+	}
+	for _, test := range tests {
+		if err := test.call(); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	if err := app.Seek(3 * time.Second); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	status, err := app.State()
+	if err != nil {
+		t.Fatalf("State: %v", err)
+	}
+	if status.Album != one.ID || status.Position != 3*time.Second || status.Chapter != 1 || status.ChapterTitle != "Chapter 2" {
+		t.Errorf("unexpected state after seeking: %+v", status)
+	}
+}
+
+func TestAPIWithoutMedia(t *testing.T) {
+	app := newTestApp(t, Options{Config: Config{MediaDirs: []string{t.TempDir()}}})
+	if err := app.Play(); err != ErrNoMedia {
+		t.Errorf("Play() = %v, expected %v", err, ErrNoMedia)
+	}
+	if err := app.Seek(0); err != ErrNoMedia {
+		t.Errorf("Seek() = %v, expected %v", err, ErrNoMedia)
+	}
+	if status, err := app.State(); err != nil || status.State != Stopped || len(status.Album) > 0 {
+		t.Errorf("State() = %+v, %v", status, err)
+	}
+}
+
+func TestAPIAfterClose(t *testing.T) {
+	app := newTestApp(t, Options{Config: Config{MediaDirs: []string{t.TempDir()}}})
+	if err := app.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := app.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if err := app.Play(); err != ErrStopped {
+		t.Errorf("Play() = %v, expected %v", err, ErrStopped)
+	}
+	if _, err := app.State(); err != ErrStopped {
+		t.Errorf("State() = %v, expected %v", err, ErrStopped)
+	}
+}
+
+func TestAPIConcurrentCalls(t *testing.T) {
+	app := newTestApp(t, Options{Config: Config{MediaDirs: []string{createLibrary(t)}}})
+	albums := []Album{findTestAlbum(t, app, "One"), findTestAlbum(t, app, "Two")}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				var err error
+				switch (g + i) % 5 {
+				case 0:
+					err = app.Play()
+				case 1:
+					err = app.Pause()
+				case 2:
+					// Within both albums
+					err = app.Seek(time.Duration(i%4) * time.Second)
+				case 3:
+					err = app.SelectAlbum(albums[i%2].ID)
+				case 4:
+					var status PlayerStatus
+					status, err = app.State()
+					if err == nil && status.Album != albums[0].ID && status.Album != albums[1].ID {
+						t.Errorf("State() has unknown album %q", status.Album)
+					}
+					if err == nil && status.Position < 0 {
+						t.Errorf("State() has position %v", status.Position)
+					}
+				}
+				if err != nil {
+					t.Errorf("goroutine %d, call %d: %v", g, i, err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := app.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package jukybox
+
+import (
+	"fmt"
+)
+
+// Reference to an album or a chapter of an album in the library
+type QueueItem struct {
+	// File of the album
+	Album string
+	// Index of the chapter, or -1 to play the entire album
+	Chapter int
+}
+
+// Albums and chapters to play before continuing in library order
+type playQueue struct {
+	items []QueueItem
+}
+
+func (q *playQueue) len() int {
+	return len(q.items)
+}
+
+func (q *playQueue) append(item QueueItem) {
+	q.items = append(q.items, item)
+}
+
+func (q *playQueue) playNext(item QueueItem) {
+	q.items = append([]QueueItem{item}, q.items...)
+}
+
+func (q *playQueue) remove(index int) error {
+	if index < 0 || index >= len(q.items) {
+		return fmt.Errorf("Invalid queue index: %d", index)
+	}
+	q.items = append(q.items[:index], q.items[index+1:]...)
+	return nil
+}
+
+func (q *playQueue) move(from int, to int) error {
+	if from < 0 || from >= len(q.items) {
+		return fmt.Errorf("Invalid queue index: %d", from)
+	}
+	if to < 0 || to >= len(q.items) {
+		return fmt.Errorf("Invalid queue index: %d", to)
+	}
+	item := q.items[from]
+	q.items = append(q.items[:from], q.items[from+1:]...)
+	q.items = append(q.items[:to], append([]QueueItem{item}, q.items[to:]...)...)
+	return nil
+}
+
+func (q *playQueue) clear() {
+	q.items = nil
+}
+
+func (q *playQueue) pop() (QueueItem, bool) {
+	if len(q.items) == 0 {
+		return QueueItem{}, false
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	return item, true
+}
+
+func (q *playQueue) list() []QueueItem {
+	return append([]QueueItem{}, q.items...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// App API
+////////////////////////////////////////////////////////////////////////////////
+
+func (app *App) validateQueueItem(item QueueItem) error {
+	mediaFile, ok := app.mediaFilesByFile[item.Album]
+	if !ok {
+		return fmt.Errorf("Unknown album: %s", item.Album)
+	}
+	if item.Chapter < -1 || item.Chapter >= len(mediaFile.file.chapters) {
+		return fmt.Errorf("Invalid chapter for %s: %d", item.Album, item.Chapter)
+	}
+	return nil
+}
+
+// QueueAppend adds an album (chapter -1) or a chapter to the end of the queue.
+func (app *App) QueueAppend(album string, chapter int) error {
+	return app.call(func() error {
+		item := QueueItem{Album: album, Chapter: chapter}
+		if err := app.validateQueueItem(item); err != nil {
+			return err
+		}
+		app.queue.append(item)
+		return nil
+	})
+}
+
+// QueuePlayNext adds an album (chapter -1) or a chapter to the front of the
+// queue.
+func (app *App) QueuePlayNext(album string, chapter int) error {
+	return app.call(func() error {
+		item := QueueItem{Album: album, Chapter: chapter}
+		if err := app.validateQueueItem(item); err != nil {
+			return err
+		}
+		app.queue.playNext(item)
+		return nil
+	})
+}
+
+func (app *App) QueueRemove(index int) error {
+	return app.call(func() error {
+		return app.queue.remove(index)
+	})
+}
+
+func (app *App) QueueMove(from int, to int) error {
+	return app.call(func() error {
+		return app.queue.move(from, to)
+	})
+}
+
+func (app *App) QueueClear() error {
+	return app.call(func() error {
+		app.queue.clear()
+		return nil
+	})
+}
+
+func (app *App) Queue() ([]QueueItem, error) {
+	var items []QueueItem
+	err := app.call(func() error {
+		items = app.queue.list()
+		return nil
+	})
+	return items, err
+}
@@ -2,35 +2,174 @@ package jukybox
 
 import (
 	"github.com/chbmuc/lirc"
+	"sync"
+	"time"
 )
 
+// Time between repeated events while a key is held
+const lircRepeatInterval = 110 * time.Millisecond
+
+// Time without repeats after which a key counts as released
+const lircReleaseTimeout = 250 * time.Millisecond
+
+// How long MENU is held to make the + and - keys change the volume
+const lircVolumeHoldDuration = 700 * time.Millisecond
+
+// Time after the last volume change at which + and - change albums again
+const lircVolumeModeDuration = 3 * time.Second
+
+// The Apple remote only has six keys, so some keys do something else when
+// held. Their tap action is sent when they are released, since only then it's
+// known that they weren't held. Tapping MENU sets the start of a loop, and
+// tapping it again the end. Number entry and audio track selection need a
+// remote with digit and AUDIO keys, or CEC.
+type lircHoldKey struct {
+	duration time.Duration
+	tap      func()
+	hold     func()
+}
+
+type lircRemote struct {
+	buttonEvents chan<- Button
+	holdKeys     map[string]lircHoldKey
+
+	mu        sync.Mutex
+	prevEvent lirc.Event
+	// Counts events, so that release timers know whether the key is still held
+	eventCount int
+	// Key with a hold action that was pressed last, until it's released
+	heldKey    *lircHoldKey
+	heldButton string
+	// Whether the hold action of the key being held was sent
+	holdSent bool
+	// Until when the + and - keys change the volume
+	volumeModeEnd time.Time
+}
+
 func CreateLIRCRemote(buttonEvents chan<- Button) {
 	ir, err := lirc.Init("/var/run/lirc/lircd")
 	if err != nil {
 		panic(err)
 	}
-	prevEvent := lirc.Event{}
-	ir.Handle("", "", func(event lirc.Event) {
-		if prevEvent.Button == event.Button && prevEvent.Remote == event.Remote && event.Repeat > prevEvent.Repeat {
-			return
+	r := &lircRemote{buttonEvents: buttonEvents}
+	r.holdKeys = map[string]lircHoldKey{
+		"KEY_MENU": {
+			duration: lircVolumeHoldDuration,
+			tap:      func() { buttonEvents <- AButton },
+			hold: func() {
+				r.volumeModeEnd = time.Now().Add(lircVolumeModeDuration)
+				buttonEvents <- ShowVolumeButton
+			},
+		},
+		"KEY_PLAY": {
+			duration: favouriteHoldDuration,
+			tap:      func() { buttonEvents <- PlayPauseButton },
+			hold:     func() { buttonEvents <- FavouriteButton },
+		},
+		"KEY_FASTFORWARD": {
+			duration: favouriteHoldDuration,
+			tap:      func() { buttonEvents <- NextTrackButton },
+			hold:     func() { buttonEvents <- FavouritesOnlyButton },
+		},
+	}
+	ir.Handle("", "", r.handle)
+	go ir.Run()
+}
+
+func (r *lircRemote) handle(event lirc.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.eventCount++
+	if r.heldKey != nil && (event.Button != r.heldButton || event.Repeat == 0) {
+		r.releaseHeldKey()
+	}
+
+	// Volume keys repeat while being held
+	switch event.Button {
+	case "KEY_VOLUMEUP":
+		r.buttonEvents <- VolumeUpButton
+		return
+	case "KEY_VOLUMEDOWN":
+		r.buttonEvents <- VolumeDownButton
+		return
+	}
+	if time.Now().Before(r.volumeModeEnd) && (event.Button == "KEY_KPPLUS" || event.Button == "KEY_KPMINUS") {
+		r.volumeModeEnd = time.Now().Add(lircVolumeModeDuration)
+		if event.Button == "KEY_KPPLUS" {
+			r.buttonEvents <- VolumeUpButton
+		} else {
+			r.buttonEvents <- VolumeDownButton
 		}
-		switch event.Button {
-		case "KEY_KPPLUS":
-			buttonEvents <- PreviousAlbumButton
-		case "KEY_KPMINUS":
-			buttonEvents <- NextAlbumButton
-		case "KEY_REWIND":
-			buttonEvents <- PreviousTrackButton
-		case "KEY_FASTFORWARD":
-			buttonEvents <- NextTrackButton
-		case "KEY_PLAY":
-			buttonEvents <- PlayPauseButton
-		case "KEY_MENU":
-			buttonEvents <- AButton
+		return
+	}
+	if key, ok := r.holdKeys[event.Button]; ok {
+		r.handleHoldKey(event, key)
+		return
+	}
+	if event.Button == "KEY_OK" {
+		if event.Repeat == int(favouriteHoldDuration/lircRepeatInterval) {
+			r.buttonEvents <- FavouriteButton
+		}
+		return
+	}
+	if r.prevEvent.Button == event.Button && r.prevEvent.Remote == event.Remote && event.Repeat > r.prevEvent.Repeat {
+		return
+	}
+	switch event.Button {
+	case "KEY_KPPLUS":
+		r.buttonEvents <- PreviousAlbumButton
+	case "KEY_KPMINUS":
+		r.buttonEvents <- NextAlbumButton
+	case "KEY_REWIND":
+		r.buttonEvents <- PreviousTrackButton
+	case "KEY_MUTE":
+		r.buttonEvents <- MuteButton
+	case "KEY_AUDIO":
+		r.buttonEvents <- AudioTrackButton
+	case "KEY_FAVORITES":
+		r.buttonEvents <- FavouritesOnlyButton
+	case "KEY_0", "KEY_1", "KEY_2", "KEY_3", "KEY_4", "KEY_5", "KEY_6", "KEY_7", "KEY_8", "KEY_9":
+		r.buttonEvents <- DigitButton(int(event.Button[4] - '0'))
+	case "KEY_CHANNEL":
+		r.buttonEvents <- ChapterModeButton
+	case "KEY_ENTER":
+		r.buttonEvents <- EnterButton
+	case "KEY_RED":
+		r.buttonEvents <- AButton
+	case "KEY_GREEN":
+		r.buttonEvents <- BButton
+	}
+	r.prevEvent = event
+}
+
+// Called with the lock held
+func (r *lircRemote) handleHoldKey(event lirc.Event, key lircHoldKey) {
+	if r.heldKey == nil {
+		r.heldKey = &key
+		r.heldButton = event.Button
+		r.holdSent = false
+	}
+	if !r.holdSent && event.Repeat >= int(key.duration/lircRepeatInterval) {
+		r.holdSent = true
+		key.hold()
+	}
+	eventCount := r.eventCount
+	time.AfterFunc(lircReleaseTimeout, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.eventCount == eventCount && r.heldKey != nil {
+			r.releaseHeldKey()
 		}
-		prevEvent = event
 	})
-	go ir.Run()
+}
+
+// Called with the lock held
+func (r *lircRemote) releaseHeldKey() {
+	if !r.holdSent {
+		// Released before it was held long enough
+		r.heldKey.tap()
+	}
+	r.heldKey = nil
 }
 
 var cecRemote *CECRemote
@@ -6,7 +6,7 @@
 #include "remote_cec.h"
 
 
-extern void go_callback_int(int foo, int p1);
+extern void go_callback_int(int foo, int p1, int p2);
 
 using namespace CEC;
 
@@ -64,12 +64,12 @@ void CecKeyPress(void* cbParam, const cec_keypress* key) {
 	if (key->duration == 0) {
 		return;
 	}
-	go_callback_int(((CECRemote*) cbParam)->handleKeyPressCB, key->keycode);
+	go_callback_int(((CECRemote*) cbParam)->handleKeyPressCB, key->keycode, key->duration);
 }
 
 void CecCommand(void* cbParam, const cec_command* command)
 {
-	go_callback_int(((CECRemote*) cbParam)->handleCommandCB, command->opcode);
+	go_callback_int(((CECRemote*) cbParam)->handleCommandCB, command->opcode, 0);
 }
 
 void CecAlert(void* cbParam, const libcec_alert type, const libcec_parameter param)
@@ -10,6 +10,7 @@ import "C"
 import (
 	"log"
 	"sync"
+	"time"
 )
 
 type CECRemote struct {
@@ -22,8 +23,8 @@ type CECRemote struct {
 func CreateCECRemote(buttonEvents chan<- Button) *CECRemote {
 	result := CECRemote{}
 	result.buttonEvents = buttonEvents
-	result.handleKeyPressCB = register(func(c C.int) { result.handleKeyPress(int(c)) })
-	result.handleCommandCB = register(func(c C.int) { result.handleCommand(int(c)) })
+	result.handleKeyPressCB = register(func(c C.int, duration C.int) { result.handleKeyPress(int(c), time.Duration(duration)*time.Millisecond) })
+	result.handleCommandCB = register(func(c C.int, _ C.int) { result.handleCommand(int(c)) })
 	result.remote = C.newCECRemote(C.int(result.handleKeyPressCB), C.int(result.handleCommandCB))
 	return &result
 }
@@ -34,9 +35,30 @@ func (r *CECRemote) Destroy() {
 	unregister(r.handleCommandCB)
 }
 
-func (r *CECRemote) handleKeyPress(code int) {
-	log.Printf("CEC: KeyPress 0x%x", code)
+// Keys are reported when they are released, so we know how long they were held
+func (r *CECRemote) handleKeyPress(code int, duration time.Duration) {
+	log.Printf("CEC: KeyPress 0x%x (%v)", code, duration)
 	switch code {
+	case 0x0:
+		if duration >= favouriteHoldDuration {
+			r.buttonEvents <- FavouriteButton
+		}
+	case 0x71:
+		// Blue
+		r.buttonEvents <- FavouritesOnlyButton
+	case 0x72:
+		// Red
+		r.buttonEvents <- AButton
+	case 0x73:
+		// Green
+		r.buttonEvents <- BButton
+	case 0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29:
+		r.buttonEvents <- DigitButton(code - 0x20)
+	case 0x2A:
+		// Dot
+		r.buttonEvents <- ChapterModeButton
+	case 0x2B:
+		r.buttonEvents <- EnterButton
 	case 0x1:
 		r.buttonEvents <- PreviousAlbumButton
 	case 0x2:
@@ -45,6 +67,12 @@ func (r *CECRemote) handleKeyPress(code int) {
 		r.buttonEvents <- PreviousTrackButton
 	case 0x4:
 		r.buttonEvents <- NextTrackButton
+	case 0x41:
+		r.buttonEvents <- VolumeUpButton
+	case 0x42:
+		r.buttonEvents <- VolumeDownButton
+	case 0x43:
+		r.buttonEvents <- MuteButton
 		// case 0x49:
 		// 	r.buttonEvents <- FastForwardButton
 		// case 0x48:
@@ -66,16 +94,16 @@ func (r *CECRemote) handleCommand(code int) {
 ////////////////////////////////////////////////////////////////////////////////
 
 //export go_callback_int
-func go_callback_int(cb C.int, a1 C.int) {
+func go_callback_int(cb C.int, a1 C.int, a2 C.int) {
 	fn := lookup(int(cb))
-	fn(a1)
+	fn(a1, a2)
 }
 
 var mu sync.Mutex
 var index int
-var fns = make(map[int]func(C.int))
+var fns = make(map[int]func(C.int, C.int))
 
-func register(fn func(C.int)) int {
+func register(fn func(C.int, C.int)) int {
 	mu.Lock()
 	defer mu.Unlock()
 	index++
@@ -86,7 +114,7 @@ func register(fn func(C.int)) int {
 	return index
 }
 
-func lookup(i int) func(C.int) {
+func lookup(i int) func(C.int, C.int) {
 	mu.Lock()
 	defer mu.Unlock()
 	return fns[i]
@@ -0,0 +1,79 @@
+package jukybox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State that is remembered across restarts
+type savedState struct {
+	file string
+
+	// Playback speed per album file
+	Speeds map[string]float64 `json:"speeds"`
+	Volume float64            `json:"volume"`
+	// Selected audio stream per album file
+	AudioStreams map[string]int `json:"audioStreams"`
+	// Time listened on ListenedDay ("2006-01-02"), for the kid mode budget
+	ListenedDay string        `json:"listenedDay"`
+	Listened    time.Duration `json:"listened"`
+	// Favourite chapters per album file, or -1 for the whole album
+	Favourites     map[string][]int `json:"favourites"`
+	FavouritesOnly bool             `json:"favouritesOnly"`
+}
+
+func loadSavedState(dir string) *savedState {
+	state := savedState{
+		file:         filepath.Join(dir, "state.json"),
+		Speeds:       map[string]float64{},
+		Volume:       0.8,
+		AudioStreams: map[string]int{},
+		Favourites:   map[string][]int{},
+	}
+	data, err := ioutil.ReadFile(state.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error loading state: %v", err)
+		}
+		return &state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Error loading state: %v", err)
+	}
+	if state.Speeds == nil {
+		state.Speeds = map[string]float64{}
+	}
+	if state.AudioStreams == nil {
+		state.AudioStreams = map[string]int{}
+	}
+	if state.Favourites == nil {
+		state.Favourites = map[string][]int{}
+	}
+	return &state
+}
+
+func (s *savedState) save() {
+	data, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("Error saving state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		log.Printf("Error saving state: %v", err)
+		return
+	}
+	// Write to a temporary file first, so that a power cut doesn't leave us with
+	// a corrupt state file
+	tmpFile := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		log.Printf("Error saving state: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFile, s.file); err != nil {
+		log.Printf("Error saving state: %v", err)
+	}
+}